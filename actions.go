@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivo/tview"
+
+	"github.com/wiltaylor/kn/markdown"
+)
+
+// Action is a named command that keys can be bound to. Keymaps lists the
+// keymaps the action can be bound in and Label is its short name on the
+// toolbar. Run gets the arguments typed after the action in the command
+// palette, none when it is run from a key, and Args describes them.
+type Action struct {
+	Name    string
+	Label   string
+	Help    string
+	Args    string
+	Keymaps []string
+	Run     func(args []string)
+}
+
+// Keymap is a named set of bindings that is active in one view or pane.
+type Keymap struct {
+	Name string
+	Help string
+}
+
+// Keymaps lists the keymaps in the order the help overlay shows them.
+var Keymaps = []Keymap{
+	{Name: "main", Help: "Note viewer"},
+	{Name: "outline", Help: "Outline pane"},
+	{Name: "search", Help: "Search screen"},
+	{Name: "results", Help: "Search results"},
+	{Name: "types", Help: "Search type filters"},
+	{Name: "picker", Help: "Note picker"},
+	{Name: "menu", Help: "Menus and prompts"},
+	{Name: "palette", Help: "Command palette"},
+	{Name: "help", Help: "Help"},
+}
+
+// Actions is the registry of every action, in the order the toolbar and help
+// list them.
+var Actions []*Action
+
+func init() {
+	Actions = []*Action{
+		{Name: "quit", Label: "Quit", Help: "Quit kn", Keymaps: []string{"main"}, Run: func(args []string) {
+			ShutdownUI()
+		}},
+		{Name: "new", Label: "New", Help: "Create a note of the default type", Args: "[title]", Keymaps: []string{"main"}, Run: func(args []string) {
+			CreateNote(Settings.DefaultType, strings.Join(args, " "))
+		}},
+		{Name: "new_typed", Label: "NewTyped", Help: "Create a note of a chosen type", Args: "[type] [title]", Keymaps: []string{"main"}, Run: func(args []string) {
+			if len(args) > 0 {
+				CreateNote(NoteType(strings.ToLower(args[0])), strings.Join(args[1:], " "))
+				return
+			}
+
+			types := KnownTypes()
+			names := make([]string, 0, len(types))
+			for _, t := range types {
+				names = append(names, t.String())
+			}
+
+			ShowMenu("New note type", names, func(idx int) {
+				SwitchView(ViewModeMain)
+				CreateNote(types[idx], "")
+			})
+		}},
+		{Name: "find", Label: "Find", Help: "Search notes", Keymaps: []string{"main"}, Run: func(args []string) {
+			SwitchView(ViewModeSearch)
+		}},
+		{Name: "edit", Label: "Edit", Help: "Edit the current note", Keymaps: []string{"main"}, Run: func(args []string) {
+			if CurrentNote.Header.Filename != "" {
+				EditFile(CurrentNote.Header.Filename)
+				RefreshNote(CurrentNote.Header.Id)
+				RefreshFileView()
+			}
+		}},
+		{Name: "add_link", Label: "AddLink", Help: "Search for a note to link from the current note", Keymaps: []string{"main"}, Run: func(args []string) {
+			SwitchView(ViewModeSearchLink)
+		}},
+		{Name: "delete", Label: "DeleteNote", Help: "Delete the current note", Keymaps: []string{"main"}, Run: func(args []string) {
+			DeleteCurrentNote()
+		}},
+		{Name: "copy_id", Label: "CopyId", Help: "Copy the id of the current note or search result", Keymaps: []string{"main", "results"}, Run: func(args []string) {
+			id := CurrentNote.Header.Id
+
+			if CurrentViewMode == ViewModeSearch || CurrentViewMode == ViewModeSearchLink {
+				if CurrentSearchSelection < 0 || CurrentSearchSelection >= len(CurrentSearchResults) {
+					return
+				}
+
+				id = CurrentSearchResults[CurrentSearchSelection].Id
+			}
+
+			CopyText(id)
+		}},
+		{Name: "next_task", Label: "NextTask", Help: "Highlight the next task", Keymaps: []string{"main"}, Run: func(args []string) {
+			tasks := markdown.Parse(CurrentNote.RawText).Tasks()
+
+			if len(tasks) == 0 {
+				return
+			}
+
+			CurrentTaskIndex++
+
+			if CurrentTaskIndex >= len(tasks) {
+				CurrentTaskIndex = 0
+			}
+
+			textbox.Highlight(fmt.Sprintf("task-%d", CurrentTaskIndex))
+			textbox.ScrollToHighlight()
+		}},
+		{Name: "toggle_task", Label: "ToggleTask", Help: "Tick or untick the highlighted task", Keymaps: []string{"main"}, Run: func(args []string) {
+			if CurrentTaskIndex == -1 || CurrentNote.Header.Type == ReportNote {
+				return
+			}
+
+			if err := ToggleTask(&CurrentNote, CurrentTaskIndex); err != nil {
+				return
+			}
+
+			RefreshNote(CurrentNote.Header.Id)
+			RenderCurrentNote()
+			textbox.Highlight(fmt.Sprintf("task-%d", CurrentTaskIndex))
+		}},
+		{Name: "next_state", Label: "NextState", Help: "Move the note to the next state of its workflow", Keymaps: []string{"main"}, Run: func(args []string) {
+			if CurrentNote.Header.Type != ReportNote && CurrentNote.Header.Filename != "" {
+				SetCurrentState(NextState(CurrentNote.Header.Type, CurrentNote.Header.State))
+			}
+		}},
+		{Name: "set_state", Label: "SetState", Help: "Pick the state of the note", Keymaps: []string{"main"}, Run: func(args []string) {
+			if CurrentNote.Header.Type == ReportNote || CurrentNote.Header.Filename == "" {
+				return
+			}
+
+			states := Workflow(CurrentNote.Header.Type)
+			names := make([]string, 0, len(states))
+			for _, state := range states {
+				names = append(names, state.String())
+			}
+
+			ShowMenu("State", names, func(idx int) {
+				SwitchView(ViewModeMain)
+				SetCurrentState(states[idx])
+			})
+		}},
+		{Name: "outline", Label: "Outline", Help: "Show or hide the outline pane", Keymaps: []string{"main", "outline"}, Run: func(args []string) {
+			ToggleOutline()
+		}},
+		{Name: "fold", Label: "Fold", Help: "Fold or unfold the current section", Keymaps: []string{"main", "outline"}, Run: func(args []string) {
+			if outline.HasFocus() {
+				ToggleFold(outline.GetCurrentItem())
+				return
+			}
+
+			ToggleFold(CurrentHeadingIndex)
+		}},
+		{Name: "next_heading", Label: "NextHeading", Help: "Jump to the next heading", Keymaps: []string{"main"}, Run: func(args []string) {
+			NextHeading(1)
+		}},
+		{Name: "prev_heading", Label: "PrevHeading", Help: "Jump to the previous heading", Keymaps: []string{"main"}, Run: func(args []string) {
+			NextHeading(-1)
+		}},
+		{Name: "follow_link", Label: "FollowLink", Help: "Open the highlighted link", Keymaps: []string{"main"}, Run: func(args []string) {
+			FollowLink()
+		}},
+		{Name: "next_link", Label: "NextLink", Help: "Highlight the next link", Keymaps: []string{"main"}, Run: func(args []string) {
+			NextLink()
+		}},
+		{Name: "back", Label: "Back", Help: "Go back to the previous note", Keymaps: []string{"main"}, Run: func(args []string) {
+			GoBack()
+		}},
+		{Name: "dashboard", Label: "Dashboard", Help: "Open the dashboard", Keymaps: []string{"main"}, Run: func(args []string) {
+			CurrentNote = OpenReport("rp:dashboard")
+			RefreshFileView()
+		}},
+		{Name: "sync", Label: "Sync", Help: "Commit and push the notes with git", Keymaps: []string{"main"}, Run: func(args []string) {
+			app.Suspend(func() {
+				if DoDataSync() == false {
+					fmt.Println("Press enter key to continue...")
+					bufio.NewReader(os.Stdin).ReadBytes('\n')
+				}
+			})
+		}},
+		{Name: "report", Label: "Report", Help: "Open a report", Args: "[name]", Keymaps: []string{"main"}, Run: func(args []string) {
+			if len(args) > 0 {
+				OpenReportNamed(args[0])
+				return
+			}
+
+			names := ReportNames()
+			ShowMenu("Report", names, func(idx int) {
+				SwitchView(ViewModeMain)
+				OpenReportNamed(names[idx])
+			})
+		}},
+		{Name: "rename", Label: "Rename", Help: "Change the title of the note", Args: "[title]", Keymaps: []string{"main"}, Run: func(args []string) {
+			rename := func(title string) {
+				UpdateCurrentHeader(func(header *NoteHeader) { header.Title = title })
+			}
+
+			if len(args) > 0 {
+				rename(strings.Join(args, " "))
+				return
+			}
+
+			ShowPrompt("Title: ", func(title string) {
+				SwitchView(ViewModeMain)
+				rename(title)
+			})
+		}},
+		{Name: "tag", Label: "Tag", Help: "Add or remove a tag on the note", Args: "add|remove <tag>", Keymaps: []string{"main"}, Run: func(args []string) {
+			if len(args) == 2 && args[0] == "add" {
+				UpdateCurrentHeader(func(header *NoteHeader) { AddTag(header, args[1]) })
+				return
+			}
+
+			if len(args) == 2 && args[0] == "remove" {
+				UpdateCurrentHeader(func(header *NoteHeader) { RemoveTag(header, args[1]) })
+				return
+			}
+
+			if len(args) == 1 && args[0] != "add" && args[0] != "remove" {
+				UpdateCurrentHeader(func(header *NoteHeader) { AddTag(header, args[0]) })
+				return
+			}
+
+			ShowPrompt("Add tag: ", func(tag string) {
+				SwitchView(ViewModeMain)
+				UpdateCurrentHeader(func(header *NoteHeader) { AddTag(header, tag) })
+			})
+		}},
+		{Name: "export", Label: "Export", Help: "Write the note to a markdown file", Args: "[path]", Keymaps: []string{"main"}, Run: func(args []string) {
+			if len(args) > 0 {
+				ExportNote(CurrentNote, strings.Join(args, " "))
+				return
+			}
+
+			ShowPrompt("Export to: ", func(path string) {
+				SwitchView(ViewModeMain)
+				ExportNote(CurrentNote, path)
+			})
+		}},
+		{Name: "open_attachment", Label: "Attachment", Help: "Open an attachment", Args: "[file]", Keymaps: []string{"main"}, Run: func(args []string) {
+			if len(args) > 0 {
+				OpenExternal(filepath.Join(NoteDirectory, ".attachments", args[0]))
+				return
+			}
+
+			files, err := Attachments()
+
+			if err != nil || len(files) == 0 {
+				return
+			}
+
+			ShowMenu("Attachment", files, func(idx int) {
+				SwitchView(ViewModeMain)
+				OpenExternal(filepath.Join(NoteDirectory, ".attachments", files[idx]))
+			})
+		}},
+		{Name: "switch_vault", Label: "Vault", Help: "Switch to another vault", Args: "[name]", Keymaps: []string{"main"}, Run: func(args []string) {
+			if len(args) > 0 {
+				SwitchVault(args[0])
+				return
+			}
+
+			names := VaultNames()
+
+			if len(names) == 0 {
+				return
+			}
+
+			ShowMenu("Vault", names, func(idx int) {
+				SwitchView(ViewModeMain)
+				SwitchVault(names[idx])
+			})
+		}},
+		{Name: "palette", Label: "Commands", Help: "Open the command palette", Keymaps: []string{"main"}, Run: func(args []string) {
+			ShowPalette()
+		}},
+		{Name: "help", Label: "Help", Help: "Show the key bindings", Keymaps: []string{"main", "outline", "results"}, Run: func(args []string) {
+			ShowHelp()
+		}},
+		{Name: "jump", Label: "Jump", Help: "Jump to the selected heading", Keymaps: []string{"outline"}, Run: func(args []string) {
+			JumpToHeading(outline.GetCurrentItem())
+			app.SetFocus(textbox)
+		}},
+		{Name: "focus_note", Label: "Note", Help: "Move focus back to the note", Keymaps: []string{"outline"}, Run: func(args []string) {
+			app.SetFocus(textbox)
+		}},
+		{Name: "open_result", Label: "Open", Help: "Open or link the selected note", Keymaps: []string{"search"}, Run: func(args []string) {
+			OpenSearchResult()
+		}},
+		{Name: "next_field", Label: "NextField", Help: "Move between the search field, results and filters", Keymaps: []string{"search"}, Run: func(args []string) {
+			if searchField.HasFocus() {
+				app.SetFocus(searchResult)
+			} else if searchResult.HasFocus() {
+				app.SetFocus(typeForm)
+			} else {
+				app.SetFocus(searchField)
+			}
+		}},
+		{Name: "search_mode", Label: "Mode", Help: "Cycle fuzzy, regex and exact search", Keymaps: []string{"search"}, Run: func(args []string) {
+			CurrentSearchMode = (CurrentSearchMode + 1) % (SearchExact + 1)
+			SearchUpdate(searchField.GetText())
+		}},
+		{Name: "refresh", Label: "Refresh", Help: "Reload the notes from disk", Keymaps: []string{"search"}, Run: func(args []string) {
+			RefreshNotes()
+			SearchUpdate(searchField.GetText())
+		}},
+		{Name: "mark", Label: "Mark", Help: "Mark or unmark the selected result", Keymaps: []string{"results"}, Run: func(args []string) {
+			ToggleMark()
+		}},
+		{Name: "bulk", Label: "Bulk", Help: "Run an action on the marked results", Keymaps: []string{"results"}, Run: func(args []string) {
+			ShowBulkMenu()
+		}},
+		{Name: "sort", Label: "Sort", Help: "Sort by the next column", Keymaps: []string{"results"}, Run: func(args []string) {
+			CurrentSearchSort = (CurrentSearchSort + 1) % (ColumnBacklinks + 1)
+			SearchUpdate(searchField.GetText())
+		}},
+		{Name: "reverse_sort", Label: "Reverse", Help: "Reverse the sort order", Keymaps: []string{"results"}, Run: func(args []string) {
+			CurrentSearchDesc = !CurrentSearchDesc
+			SearchUpdate(searchField.GetText())
+		}},
+		{Name: "prev_filter", Label: "Prev", Help: "Focus the previous type filter", Keymaps: []string{"types"}, Run: func(args []string) {
+			MoveTypeFocus(-1)
+		}},
+		{Name: "next_filter", Label: "Next", Help: "Focus the next type filter", Keymaps: []string{"types"}, Run: func(args []string) {
+			MoveTypeFocus(1)
+		}},
+		{Name: "pick", Label: "Pick", Help: "Open the selected note", Keymaps: []string{"picker"}, Run: func(args []string) {
+			idx := picker.GetCurrentItem()
+			SwitchView(ViewModeMain)
+
+			if idx >= 0 && idx < len(PickerResults) {
+				OpenNote(PickerResults[idx])
+			}
+		}},
+		{Name: "run_command", Label: "Run", Help: "Run the selected command", Keymaps: []string{"palette"}, Run: func(args []string) {
+			RunPaletteCommand()
+		}},
+		{Name: "next_command", Label: "Next", Help: "Select the next command", Keymaps: []string{"palette"}, Run: func(args []string) {
+			MovePaletteSelection(1)
+		}},
+		{Name: "prev_command", Label: "Prev", Help: "Select the previous command", Keymaps: []string{"palette"}, Run: func(args []string) {
+			MovePaletteSelection(-1)
+		}},
+		{Name: "close", Label: "Close", Help: "Close the screen", Keymaps: []string{"search", "picker", "menu", "palette", "help"}, Run: func(args []string) {
+			CloseView()
+		}},
+	}
+}
+
+// DefaultKeys returns the default bindings by keymap and action. A binding is
+// a key, a sequence of keys such as ]] or "g g", or alternatives separated by
+// commas.
+func DefaultKeys() map[string]map[string]string {
+	return map[string]map[string]string{
+		"main": {
+			"quit":         "Esc",
+			"new":          "n",
+			"new_typed":    "N",
+			"find":         "f",
+			"edit":         "e",
+			"add_link":     "a",
+			"delete":       "d",
+			"copy_id":      "c",
+			"next_task":    "t",
+			"toggle_task":  "x",
+			"next_state":   "w",
+			"set_state":    "W",
+			"outline":      "o",
+			"fold":         "z",
+			"next_heading": "]]",
+			"prev_heading": "[[",
+			"follow_link":  "Enter",
+			"next_link":    "Tab",
+			"back":         "Backspace",
+			"dashboard":    "F1",
+			"sync":         "F10",
+			"switch_vault": "V",
+			"palette":      ":,Ctrl-P",
+			"help":         "?",
+		},
+		"outline": {
+			"jump":       "Enter",
+			"fold":       "z",
+			"outline":    "o",
+			"focus_note": "Esc,Tab",
+			"help":       "?",
+		},
+		"search": {
+			"close":       "Esc",
+			"open_result": "Enter",
+			"next_field":  "Tab",
+			"search_mode": "F2",
+			"refresh":     "F5",
+		},
+		"results": {
+			"mark":         "Space",
+			"bulk":         "b",
+			"sort":         "s",
+			"reverse_sort": "S",
+			"copy_id":      "c",
+			"help":         "?",
+		},
+		"types": {
+			"prev_filter": "Left",
+			"next_filter": "Right",
+		},
+		"picker": {
+			"close": "Esc",
+			"pick":  "Enter",
+		},
+		"menu": {
+			"close": "Esc",
+		},
+		"palette": {
+			"close":        "Esc",
+			"run_command":  "Enter",
+			"next_command": "Down,Tab,Ctrl-N",
+			"prev_command": "Up,Backtab,Ctrl-P",
+		},
+		"help": {
+			"close": "Esc,?,q",
+		},
+	}
+}
+
+// LookupAction returns the action with the given name.
+func LookupAction(name string) (*Action, bool) {
+	for _, action := range Actions {
+		if action.Name == name {
+			return action, true
+		}
+	}
+
+	return nil, false
+}
+
+// bindable reports if the action can be bound in the keymap.
+func (a *Action) bindable(keymap string) bool {
+	for _, name := range a.Keymaps {
+		if name == keymap {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckKeys reports the first binding in the settings that names an unknown
+// keymap or action or can't be parsed.
+func CheckKeys(keys map[string]map[string]string) error {
+	for keymap, bindings := range keys {
+		known := false
+		for _, k := range Keymaps {
+			if k.Name == keymap {
+				known = true
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("unknown keymap %s", keymap)
+		}
+
+		for name, text := range bindings {
+			action, ok := LookupAction(name)
+
+			if !ok || !action.bindable(keymap) {
+				return fmt.Errorf("no action %s in the %s keymap", name, keymap)
+			}
+
+			if _, err := ParseBinding(text); err != nil {
+				return fmt.Errorf("key for %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ActiveKeymaps returns the keymaps for the focused view, the most specific
+// one first.
+func ActiveKeymaps() []string {
+	switch CurrentViewMode {
+	case ViewModeMain:
+		if outline.HasFocus() {
+			return []string{"outline"}
+		}
+		return []string{"main"}
+	case ViewModeSearch, ViewModeSearchLink:
+		if searchResult.HasFocus() {
+			return []string{"results", "search"}
+		}
+		if typeForm.HasFocus() {
+			return []string{"types", "search"}
+		}
+		return []string{"search"}
+	case ViewModePicker:
+		return []string{"picker"}
+	case ViewModeMenu, ViewModePrompt:
+		return []string{"menu"}
+	case ViewModePalette:
+		return []string{"palette"}
+	case ViewModeHelp:
+		return []string{"help"}
+	}
+
+	return nil
+}
+
+// KeyText returns the keys bound to the action in the keymap, or an empty
+// string when it is unbound.
+func KeyText(keymap string, action string) string {
+	return Settings.Keys[keymap][action]
+}
+
+// ToolbarText lists the bound actions of the keymap for the toolbar.
+func ToolbarText(keymap string) string {
+	items := make([]string, 0)
+
+	for _, action := range Actions {
+		if key := KeyText(keymap, action.Name); key != "" && action.bindable(keymap) {
+			items = append(items, key+"-"+action.Label)
+		}
+	}
+
+	return strings.Join(items, "|")
+}
+
+// HelpText lists the bindings of every keymap for the help overlay.
+func HelpText() string {
+	var sb strings.Builder
+
+	for _, keymap := range Keymaps {
+		sb.WriteString(fmt.Sprintf("[yellow::b]%s[-:-:-]\n", keymap.Help))
+
+		for _, action := range Actions {
+			key := KeyText(keymap.Name, action.Name)
+
+			if key == "" || !action.bindable(keymap.Name) {
+				continue
+			}
+
+			sb.WriteString(fmt.Sprintf("  [green]%-12s[-] %s\n", tview.Escape(key), action.Help))
+		}
+
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+// CloseView leaves the current screen for the one it was opened from.
+func CloseView() {
+	switch CurrentViewMode {
+	case ViewModeMenu, ViewModePrompt:
+		SwitchView(menuReturn)
+	case ViewModeHelp:
+		SwitchView(helpReturn)
+	default:
+		SwitchView(ViewModeMain)
+	}
+}
+
+// FollowLink opens the highlighted link of the current note.
+func FollowLink() {
+	if CurrentLinkIndex == -1 || len(CurrentNote.Links) == 0 {
+		return
+	}
+
+	lnk := CurrentNote.Links[CurrentLinkIndex]
+
+	switch lnk.Type {
+	case markdown.LNK_URL, markdown.LNK_IMAGE:
+		target := lnk.Target
+
+		if strings.HasPrefix(target, "zka:") {
+			target = filepath.Join(NoteDirectory, ".attachments", target[4:])
+		}
+
+		OpenExternal(target)
+	case markdown.LNK_ZKA:
+		if lnk.Target == "" {
+			return
+		}
+
+		OpenExternal(filepath.Join(NoteDirectory, ".attachments", lnk.Target))
+	case markdown.LNK_REPORT:
+		CurrentNote = OpenReport("rp:" + lnk.Target)
+		RefreshFileView()
+	case markdown.LNK_ZK:
+		if header, ok := ResolveNote(lnk.Target); ok {
+			OpenNote(header)
+			return
+		}
+
+		if notes := SuggestNotes(lnk.Target); len(notes) > 0 {
+			ShowPicker(notes)
+		}
+	case markdown.LNK_UNRESOLVED:
+		if notes := SuggestNotes(lnk.Target); len(notes) > 0 {
+			ShowPicker(notes)
+		}
+	}
+}
+
+// OpenReportNamed opens a report by name, or the report of the note type
+// with that name.
+func OpenReportNamed(name string) {
+	if _, ok := LookupType(NoteType(name)); ok {
+		name = "type/" + name
+	}
+
+	CurrentNote = OpenReport("rp:" + name)
+	RefreshFileView()
+}
+
+// GoBack opens the note before the current one in the history.
+func GoBack() {
+	if len(NoteHistory) <= 1 {
+		return
+	}
+
+	id := NoteHistory[len(NoteHistory)-2]
+
+	NoteHistory = NoteHistory[0 : len(NoteHistory)-2]
+
+	head, err := GetHeaderFromFile(id)
+
+	if err != nil {
+		panic(err)
+	}
+
+	d, err := GetNoteData(head)
+
+	if err != nil {
+		panic(err)
+	}
+
+	CurrentNote = d
+	RefreshFileView()
+}
+
+// DeleteCurrentNote removes the current note and shows an empty viewer.
+func DeleteCurrentNote() {
+	RemoveNote(CurrentNote.Header.Id)
+	CurrentNote.Header.Id = ""
+	CurrentNote.Header.Filename = ""
+	CurrentNote.Header.Title = "Empty"
+	CurrentNote.RawText = ""
+	CurrentNote.Links = make([]*markdown.Link, 0)
+
+	textbox.SetText("")
+	textbox.ScrollToBeginning()
+	textbox.SetTitle("Empty")
+}
+
+// OpenSearchResult opens the selected search result, or links it from the
+// current note when adding a link.
+func OpenSearchResult() {
+	if len(CurrentSearchResults) == 0 {
+		return
+	}
+
+	if CurrentViewMode == ViewModeSearchLink {
+		note := CurrentSearchResults[CurrentSearchSelection]
+		CurrentNote.RawText += fmt.Sprintf("\n[%s](zk:%s)\n", note.Title, note.Id)
+		SaveNoteData(CurrentNote)
+	} else {
+		n, err := GetNoteData(CurrentSearchResults[CurrentSearchSelection])
+
+		if err != nil {
+			return
+		}
+
+		CurrentNote = n
+		NoteHistory = append(NoteHistory, CurrentNote.Header.Id)
+	}
+
+	RefreshFileView()
+	SwitchView(ViewModeMain)
+}
+
+// MoveTypeFocus moves focus between the type filters on the search screen,
+// wrapping at either end.
+func MoveTypeFocus(step int) {
+	count := typeForm.GetFormItemCount()
+
+	for idx := 0; idx < count; idx++ {
+		if typeForm.GetFormItem(idx).HasFocus() {
+			app.SetFocus(typeForm.GetFormItem((idx + step + count) % count))
+			return
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wiltaylor/kn/markdown"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the user settings. Values come from the defaults, then the user
+// config file and then the .kn/config.yaml file in the notes directory.
+type Config struct {
+	NotesDir string `yaml:"notes_dir"`
+	// Vaults names notes directories that can be opened with --vault or
+	// switched to in the TUI. DefaultVault is opened when none is picked.
+	Vaults       map[string]string `yaml:"vaults"`
+	DefaultVault string            `yaml:"default_vault"`
+	// Vault is the name of the open vault, empty for notes_dir or ZKDIR.
+	Vault string `yaml:"-"`
+	// Editor and Opener are the commands used to edit notes and open urls and
+	// attachments. Clipboard is the command text is copied with, it is found
+	// on the path when empty.
+	Editor    string `yaml:"editor"`
+	Opener    string `yaml:"opener"`
+	Clipboard string `yaml:"clipboard"`
+	// DefaultType is the type of notes created with the new note key.
+	DefaultType NoteType `yaml:"default_type"`
+	// IdFormat is "unix" for ids made of the unix time, or a Go time layout.
+	IdFormat string      `yaml:"id_format"`
+	Theme    ThemeConfig `yaml:"theme"`
+	// Icons replaces the icons in front of links by link kind: url, note,
+	// attachment, report, empty and image.
+	Icons map[string]string `yaml:"icons"`
+	// Keys binds action names to keys in each keymap.
+	Keys map[string]map[string]string `yaml:"keys"`
+	Sync SyncConfig                   `yaml:"sync"`
+	// Types are the registered note types in the order they are listed.
+	Types []TypeConfig `yaml:"types"`
+	// Workflows lists the states a note moves through by note type name. The
+	// default workflow is used for types without their own.
+	Workflows map[string][]NoteState `yaml:"workflows"`
+}
+
+// ThemeConfig picks the code block theme and the colors of the rest of a note.
+type ThemeConfig struct {
+	Code            string `yaml:"code"`
+	LineNumbers     bool   `yaml:"line_numbers"`
+	markdown.Colors `yaml:",inline"`
+}
+
+// SyncConfig controls how the notes git repository is synced.
+type SyncConfig struct {
+	Message string `yaml:"message"`
+	Pull    bool   `yaml:"pull"`
+	Push    bool   `yaml:"push"`
+}
+
+// TypeConfig describes a note type. Template is a markdown file used as the
+// body of new notes and State the state they start in, the first state of the
+// workflow when empty. Folder is where new notes go under the notes directory.
+// Search sets if the type is ticked on the search screen.
+type TypeConfig struct {
+	Name     string    `yaml:"name"`
+	Icon     string    `yaml:"icon"`
+	Color    string    `yaml:"color"`
+	Template string    `yaml:"template"`
+	State    NoteState `yaml:"state"`
+	Folder   string    `yaml:"folder"`
+	Search   bool      `yaml:"search"`
+}
+
+var Settings = DefaultConfig()
+
+// ConfigFiles lists the config files that were loaded.
+var ConfigFiles []string
+
+// linkKinds names the link types for the icons setting.
+var linkKinds = map[string]markdown.LinkType{
+	"url":        markdown.LNK_URL,
+	"note":       markdown.LNK_ZK,
+	"attachment": markdown.LNK_ZKA,
+	"report":     markdown.LNK_REPORT,
+	"empty":      markdown.LNK_EMPTY,
+	"image":      markdown.LNK_IMAGE,
+}
+
+func DefaultConfig() Config {
+	editor := os.Getenv("EDITOR")
+
+	if editor == "" {
+		editor = "vim"
+	}
+
+	icons := make(map[string]string)
+	for name, t := range linkKinds {
+		icons[name] = markdown.LinkIcon(t)
+	}
+
+	return Config{
+		Editor:      editor,
+		Opener:      "xdg-open",
+		DefaultType: ZettleNote,
+		IdFormat:    "unix",
+		Theme:       ThemeConfig{Code: "default", Colors: markdown.DefaultColors},
+		Icons:       icons,
+		Keys:        DefaultKeys(),
+		Sync:        SyncConfig{Message: "Syncing data", Push: true},
+		Types: []TypeConfig{
+			{Name: string(ZettleNote), Icon: "", Color: "green", Search: true},
+			{Name: string(MapNote), Icon: "", Color: "blue", Search: true},
+			{Name: string(LiteratureNote), Icon: "", Color: "yellow"},
+			{Name: string(FleetingNote), Icon: "", Color: "gray"},
+		},
+		Workflows: map[string][]NoteState{
+			"default": {NewState, ReadyState, GreenState, DoneState},
+		},
+	}
+}
+
+// ConfigPath returns the location of the user config file.
+func ConfigPath() string {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+
+	return filepath.Join(dir, "kn", "config.yaml")
+}
+
+// VaultConfigPath returns the location of the config file of a notes directory.
+func VaultConfigPath(notesDir string) string {
+	return filepath.Join(notesDir, ".kn", "config.yaml")
+}
+
+// LoadConfig reads the user config file and then the config file of the notes
+// directory over the defaults. The named vault, or ZKDIR when there is none,
+// picks the notes directory ahead of default_vault and notes_dir.
+// KN_CODE_THEME and KN_LINE_NUMBERS override the code theme. Missing files are
+// skipped.
+func LoadConfig(vault string) error {
+	Settings = DefaultConfig()
+	ConfigFiles = make([]string, 0)
+
+	if err := mergeConfigFile(ConfigPath()); err != nil {
+		return err
+	}
+
+	dir := os.Getenv("ZKDIR")
+
+	if vault == "" && dir == "" {
+		vault = Settings.DefaultVault
+	}
+
+	if vault != "" {
+		path, ok := Settings.Vaults[vault]
+
+		if !ok {
+			return fmt.Errorf("unknown vault %s", vault)
+		}
+
+		dir = path
+	}
+
+	if dir != "" {
+		Settings.NotesDir = dir
+	}
+
+	Settings.Vault = vault
+	Settings.NotesDir = expandHome(Settings.NotesDir)
+
+	// A vault can't move itself or change the list of vaults.
+	notesDir, vaults, defaultVault := Settings.NotesDir, Settings.Vaults, Settings.DefaultVault
+	if err := mergeConfigFile(VaultConfigPath(notesDir)); err != nil {
+		return err
+	}
+	Settings.NotesDir, Settings.Vaults, Settings.DefaultVault = notesDir, vaults, defaultVault
+
+	if theme := os.Getenv("KN_CODE_THEME"); theme != "" {
+		Settings.Theme.Code = theme
+	}
+
+	if os.Getenv("KN_LINE_NUMBERS") != "" {
+		Settings.Theme.LineNumbers = true
+	}
+
+	if _, ok := markdown.Themes[Settings.Theme.Code]; !ok {
+		return fmt.Errorf("unknown code theme %s", Settings.Theme.Code)
+	}
+
+	for name := range Settings.Icons {
+		if _, ok := linkKinds[name]; !ok {
+			return fmt.Errorf("unknown link icon %s", name)
+		}
+	}
+
+	if err := CheckKeys(Settings.Keys); err != nil {
+		return err
+	}
+
+	// Types without a workflow of their own fall back to the default one.
+	if len(Settings.Workflows["default"]) == 0 {
+		return fmt.Errorf("workflows has no default workflow")
+	}
+
+	NoteDirectory = Settings.NotesDir
+	return nil
+}
+
+// expandHome replaces a leading ~ in a path with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[1:])
+}
+
+// VaultNames returns the names of the configured vaults in order.
+func VaultNames() []string {
+	names := make([]string, 0, len(Settings.Vaults))
+
+	for name := range Settings.Vaults {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// SplitVaultLink splits a vault/id link target. It returns false when the
+// target doesn't start with the name of a configured vault.
+func SplitVaultLink(target string) (string, string, bool) {
+	idx := strings.Index(target, "/")
+
+	if idx == -1 {
+		return "", "", false
+	}
+
+	if _, ok := Settings.Vaults[target[:idx]]; !ok {
+		return "", "", false
+	}
+
+	return target[:idx], target[idx+1:], true
+}
+
+// mergeConfigFile reads a config file over the current settings. Note types
+// replace the type of the same name or are added after the others and key
+// bindings replace the binding of the same action, an empty one unbinds it.
+func mergeConfigFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	types := Settings.Types
+	Settings.Types = nil
+	keys := Settings.Keys
+	Settings.Keys = nil
+
+	if err := yaml.Unmarshal(data, &Settings); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, t := range Settings.Types {
+		t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+		t.State = normalState(t.State)
+
+		if t.Name == "" {
+			continue
+		}
+
+		replaced := false
+		for i := range types {
+			if types[i].Name == t.Name {
+				types[i] = t
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			types = append(types, t)
+		}
+	}
+
+	Settings.Types = types
+
+	for keymap, bindings := range Settings.Keys {
+		if keys[keymap] == nil {
+			keys[keymap] = make(map[string]string)
+		}
+
+		for action, key := range bindings {
+			if key == "" {
+				delete(keys[keymap], action)
+			} else {
+				keys[keymap][action] = key
+			}
+		}
+	}
+
+	Settings.Keys = keys
+
+	// Notes are read with lower case types and states, so the workflows have
+	// to be too.
+	workflows := make(map[string][]NoteState)
+
+	for name, states := range Settings.Workflows {
+		normal := make([]NoteState, 0, len(states))
+
+		for _, state := range states {
+			if state = normalState(state); state != UnknownState {
+				normal = append(normal, state)
+			}
+		}
+
+		if len(normal) > 0 {
+			workflows[strings.ToLower(strings.TrimSpace(name))] = normal
+		}
+	}
+
+	Settings.Workflows = workflows
+
+	ConfigFiles = append(ConfigFiles, path)
+	return nil
+}
+
+// normalState lower cases a state name the way states are read from notes.
+func normalState(state NoteState) NoteState {
+	return NoteState(strings.ToLower(strings.TrimSpace(string(state))))
+}
+
+// LinkIcons returns the configured link icons for the renderer.
+func LinkIcons() map[markdown.LinkType]string {
+	result := make(map[markdown.LinkType]string)
+
+	for name, icon := range Settings.Icons {
+		result[linkKinds[name]] = icon
+	}
+
+	return result
+}
+
+// NewNoteId makes the id for a note created at the given time.
+func NewNoteId(t time.Time) string {
+	if Settings.IdFormat == "" || Settings.IdFormat == "unix" {
+		return fmt.Sprintf("%v", t.Unix())
+	}
+
+	return t.Format(Settings.IdFormat)
+}
+
+// PrintConfig writes the effective settings as yaml.
+func PrintConfig() error {
+	data, err := yaml.Marshal(Settings)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("# Effective kn config")
+	if Settings.Vault != "" {
+		fmt.Printf("# Vault %s\n", Settings.Vault)
+	}
+	for _, path := range ConfigFiles {
+		fmt.Printf("# Loaded %s\n", path)
+	}
+
+	fmt.Print(string(data))
+	return nil
+}
+
+// NoteTypes returns the registered note types.
+func NoteTypes() []TypeConfig {
+	return Settings.Types
+}
+
+// LookupType returns the registration of a note type.
+func LookupType(t NoteType) (TypeConfig, bool) {
+	for _, cfg := range Settings.Types {
+		if cfg.Name == string(t) {
+			return cfg, true
+		}
+	}
+
+	return TypeConfig{}, false
+}
+
+// KnownTypes returns every registered note type.
+func KnownTypes() []NoteType {
+	result := make([]NoteType, 0, len(Settings.Types))
+
+	for _, cfg := range Settings.Types {
+		result = append(result, NoteType(cfg.Name))
+	}
+
+	return result
+}
+
+// TypeLabel renders a note type with its icon and color for tview.
+func TypeLabel(t NoteType) string {
+	cfg, ok := LookupType(t)
+
+	if !ok {
+		return t.String()
+	}
+
+	if cfg.Color == "" {
+		return cfg.Icon + " " + cfg.Name
+	}
+
+	return fmt.Sprintf("[%s]%s %s[-]", cfg.Color, cfg.Icon, cfg.Name)
+}
+
+// Workflow returns the states notes of the given type move through.
+func Workflow(t NoteType) []NoteState {
+	if states, ok := Settings.Workflows[t.String()]; ok {
+		return states
+	}
+
+	return Settings.Workflows["default"]
+}
+
+// StateIndex returns where state sits in the workflow of the type, or -1 when
+// it is not part of it.
+func StateIndex(t NoteType, state NoteState) int {
+	for i, s := range Workflow(t) {
+		if s == state {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// NextState returns the state after the given one in the workflow of the type.
+// The last state stays where it is and states outside the workflow restart it.
+func NextState(t NoteType, state NoteState) NoteState {
+	states := Workflow(t)
+	idx := StateIndex(t, state)
+
+	if idx == -1 {
+		return states[0]
+	}
+
+	if idx == len(states)-1 {
+		return state
+	}
+
+	return states[idx+1]
+}
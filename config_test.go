@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	home := t.TempDir()
+	notes := t.TempDir()
+
+	for key, value := range map[string]string{"XDG_CONFIG_HOME": home, "HOME": home, "ZKDIR": notes} {
+		old, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+
+		defer func(key string) {
+			if ok {
+				os.Setenv(key, old)
+			} else {
+				os.Unsetenv(key)
+			}
+		}(key)
+	}
+
+	write := func(path string, text string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0760); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(text), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(ConfigPath(), "types:\n  - name: Meeting\n    state: Scheduled\nworkflows:\n  Meeting: [Scheduled, InProgress, Done]\n")
+
+	t.Run("Lower cases states like notes are read", func(t *testing.T) {
+		if err := LoadConfig(""); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []NoteState{"scheduled", "inprogress", "done"}
+
+		if got := Workflow("meeting"); !reflect.DeepEqual(expected, got) {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+
+		var header NoteHeader
+		if err := parseHeader("Type: Meeting\nStatus: InProgress\n", &header); err != nil {
+			t.Fatal(err)
+		}
+
+		if StateIndex(header.Type, header.State) != 1 {
+			t.Errorf("Expected %s to be in the workflow of %s", header.State, header.Type)
+		}
+
+		if cfg, _ := LookupType("meeting"); cfg.State != "scheduled" {
+			t.Errorf("Expected the first state to be lower cased, got %s", cfg.State)
+		}
+	})
+
+	t.Run("Names the file that failed", func(t *testing.T) {
+		path := VaultConfigPath(notes)
+		write(path, "types: [\n")
+
+		err := LoadConfig("")
+
+		if err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("Expected an error naming %s, got %v", path, err)
+		}
+	})
+}
@@ -1,48 +1,62 @@
 package main
 
 import (
-	"bufio"
+	"crypto/sha256"
 	"errors"
 	"fmt"
-	"gopkg.in/yaml.v3"
+	"github.com/wiltaylor/kn/fuzzy"
+	"github.com/wiltaylor/kn/markdown"
+	"github.com/wiltaylor/kn/notefile"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-type NoteState int
-type NoteType int
-type LinkType int
+type NoteState string
+type NoteType string
 
+// The states of the default workflow, other states come from the config.
 const (
-	NewState NoteState = iota
-	ReadyState
-	GreenState
-	DoneState
-	UnknownState
+	NewState     NoteState = "new"
+	ReadyState   NoteState = "ready"
+	GreenState   NoteState = "green"
+	DoneState    NoteState = "done"
+	UnknownState NoteState = ""
 )
 
+// The built in note types, other types come from the config. Notes keep the
+// type written in their header even when it isn't registered.
 const (
-	ZettleNote NoteType = iota
-	MapNote
-	LiteratureNote
-	FleetingNote
-	ReportNote
-	UnknownNote
+	ZettleNote     NoteType = "zettle"
+	MapNote        NoteType = "map"
+	LiteratureNote NoteType = "literature"
+	FleetingNote   NoteType = "fleeting"
+	ReportNote     NoteType = "report"
+	UnknownNote    NoteType = ""
 )
 
-const (
-	LinkUrl LinkType = iota
-	LinkAttachment
-	LinkNote
-	LinkEmpty
-	LinkReport
-)
+func (t NoteType) String() string {
+	if t == UnknownNote {
+		return "unknown"
+	}
+
+	return string(t)
+}
+
+func (s NoteState) String() string {
+	if s == UnknownState {
+		return "unknown"
+	}
+
+	return string(s)
+}
 
 type NoteHeader struct {
 	Title    string
@@ -52,99 +66,81 @@ type NoteHeader struct {
 	Type     NoteType
 	State    NoteState
 	Tags     []string
-}
-
-type NoteHeaderYaml struct {
-	Title string   `yaml:"Title"`
-	Date  string   `yaml:"Date"`
-	Type  string   `yaml:"Type"`
-	State string   `yaml:"Status"`
-	Tags  []string `yaml:"Tags"`
-}
-
-type NoteLink struct {
-	Title string
-	Type  LinkType
-	Path  string
-	Id    int
+	Aliases  []string
 }
 
 type NoteData struct {
 	Header       NoteHeader
 	RawText      string
 	FormatedText string
-	Links        []NoteLink
+	Links        []*markdown.Link
 }
 
 var AllNotes []NoteHeader
 
-func GetHeaderFromFile(id string) (NoteHeader, error) {
-	path := filepath.Join(NoteDirectory, id+".md")
-	result := NoteHeader{Title: "", Id: id, Filename: filepath.Join(NoteDirectory, id+".md"), Date: "", Type: UnknownNote, State: UnknownState}
-
-	file, err := os.Open(path)
+// DuplicateNotes lists the files left out because a note created before them
+// already has their id.
+var DuplicateNotes []string
 
-	if err != nil {
-		return result, err
-	}
+// notePaths maps note ids to their files, notes can sit in folders under the
+// notes directory.
+var notePaths map[string]string
 
-	s := bufio.NewScanner(file)
-	defer file.Close()
+// linkIndex maps note ids to the ids of the notes they link to. It is built
+// on first use and dropped whenever notes change.
+var linkIndex map[string][]string
 
-	//Stripping the top ---
-	s.Scan()
+func GetHeaderFromFile(id string) (NoteHeader, error) {
+	return readHeader(NotePath(id), id)
+}
 
-	if s.Text() != "---" {
-		return result, errors.New("File header doesn't start with ---")
+// NotePath returns the file of the note with the given id. Notes that haven't
+// been scanned are expected at the top of the notes directory.
+func NotePath(id string) string {
+	if path, ok := notePaths[id]; ok {
+		return path
 	}
 
-	yamlText := ""
+	return filepath.Join(NoteDirectory, id+".md")
+}
 
-	for s.Scan() {
-		txt := s.Text()
+// readHeader reads the front matter of the note file at path.
+func readHeader(path string, id string) (NoteHeader, error) {
+	result := NoteHeader{Title: "", Id: id, Filename: path, Date: "", Type: UnknownNote, State: UnknownState}
 
-		if txt == "---" {
-			break
-		}
-
-		yamlText += txt + "\n"
-	}
+	data, err := ioutil.ReadFile(path)
 
-	var data NoteHeaderYaml
-	err = yaml.Unmarshal([]byte(yamlText), &data)
 	if err != nil {
 		return result, err
 	}
 
-	result.Title = data.Title
-	result.Date = data.Date
-	result.Tags = data.Tags
+	yamlText, _, ok := notefile.Split(string(data))
 
-	if data.Type == "zettle" {
-		result.Type = ZettleNote
-	} else if data.Type == "literature" {
-		result.Type = LiteratureNote
-	} else if data.Type == "fleeting" {
-		result.Type = FleetingNote
-	} else if data.Type == "map" {
-		result.Type = MapNote
-	} else {
-		result.Type = UnknownNote
+	if !ok {
+		return result, errors.New("File header doesn't start with ---")
 	}
 
-	if data.State == "new" {
-		result.State = NewState
-	} else if data.State == "done" {
-		result.State = DoneState
-	} else if data.State == "ready" {
-		result.State = ReadyState
-	} else if data.State == "green" {
-		result.State = GreenState
-	} else {
-		result.State = UnknownState
+	err = parseHeader(yamlText, &result)
+	return result, err
+}
+
+// parseHeader reads the yaml of a note's front matter into header.
+func parseHeader(yamlText string, header *NoteHeader) error {
+	data, err := notefile.Parse(yamlText)
+	if err != nil {
+		return err
 	}
 
-	return result, nil
+	header.Title = data.Title
+	header.Date = data.Date
+	header.Tags = data.Tags
+	header.Aliases = data.Aliases
+
+	header.Type = NoteType(strings.ToLower(strings.TrimSpace(data.Type)))
+
+	header.State = NoteState(strings.ToLower(strings.TrimSpace(data.State)))
+
+	return nil
 }
 
 func FindByTag(tag string, noteTypes []NoteType) []NoteHeader {
@@ -165,26 +161,59 @@ func FindByTag(tag string, noteTypes []NoteType) []NoteHeader {
 	return result
 }
 
+type SearchMode int
+
+const (
+	SearchFuzzy SearchMode = iota
+	SearchRegex
+	SearchExact
+)
+
+func (m SearchMode) String() string {
+	switch m {
+	case SearchRegex:
+		return "regex"
+	case SearchExact:
+		return "exact"
+	}
+
+	return "fuzzy"
+}
+
+// SearchResult is a note found by SearchNotes. Positions holds the rune indexes
+// of the matched characters in the title, it is empty when the note matched on
+// an alias or tag.
+type SearchResult struct {
+	Note      NoteHeader
+	Score     int
+	Positions []int
+}
+
 func FindNotes(pattern string, noteTypes []NoteType) []NoteHeader {
 	result := make([]NoteHeader, 0)
 
 	pattern = strings.ToLower(pattern)
+	re, err := regexp.Compile(pattern)
+
+	if err != nil {
+		re = regexp.MustCompile(regexp.QuoteMeta(pattern))
+	}
 
 	for _, note := range AllNotes {
-		m := false
-		for _, t := range noteTypes {
-			if note.Type == t {
-				m = true
+		if !hasType(note, noteTypes) {
+			continue
+		}
+
+		match := re.MatchString(strings.ToLower(note.Title))
+
+		for _, alias := range note.Aliases {
+			if match {
 				break
 			}
-		}
 
-		if m == false {
-			continue
+			match = re.MatchString(strings.ToLower(alias))
 		}
 
-		match, _ := regexp.MatchString(pattern, strings.ToLower(note.Title))
-
 		if match {
 			result = append(result, note)
 		}
@@ -193,41 +222,162 @@ func FindNotes(pattern string, noteTypes []NoteType) []NoteHeader {
 	return result
 }
 
-func RefreshNotes() error {
-	AllNotes = make([]NoteHeader, 0)
+// SearchNotes finds notes of the given types whose title, aliases or tags
+// match pattern. Results are ordered by score and then by the newest note
+// first. An invalid regex is returned as an error.
+func SearchNotes(pattern string, mode SearchMode, noteTypes []NoteType) ([]SearchResult, error) {
+	result := make([]SearchResult, 0)
+	match := func(text string) (fuzzy.Match, bool) { return fuzzy.Find(pattern, text) }
 
-	files, err := ioutil.ReadDir(NoteDirectory)
+	switch mode {
+	case SearchRegex:
+		re, err := regexp.Compile("(?i)" + pattern)
 
-	if err != nil {
-		return err
+		if err != nil {
+			return result, err
+		}
+
+		match = func(text string) (fuzzy.Match, bool) {
+			loc := re.FindStringIndex(text)
+
+			if loc == nil {
+				return fuzzy.Match{}, false
+			}
+
+			return fuzzy.Match{Positions: runePositions(text, loc[0], loc[1])}, true
+		}
+	case SearchExact:
+		lower := strings.ToLower(pattern)
+
+		match = func(text string) (fuzzy.Match, bool) {
+			lowerText := strings.ToLower(text)
+			idx := strings.Index(lowerText, lower)
+
+			if idx == -1 {
+				return fuzzy.Match{}, false
+			}
+
+			// Lower casing can change byte lengths, positions would be off then.
+			if len(lowerText) != len(text) {
+				return fuzzy.Match{}, true
+			}
+
+			return fuzzy.Match{Positions: runePositions(text, idx, idx+len(lower))}, true
+		}
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
+	for _, note := range AllNotes {
+		if !hasType(note, noteTypes) {
 			continue
 		}
 
-		matched, err := regexp.MatchString(`\.md$`, file.Name())
-		if err != nil {
-			continue
+		best, ok := match(note.Title)
+
+		for _, other := range append(append([]string{}, note.Aliases...), note.Tags...) {
+			m, found := match(other)
+
+			if found && (!ok || m.Score > best.Score) {
+				best = fuzzy.Match{Score: m.Score}
+				ok = true
+			}
 		}
 
-		if !matched {
-			continue
+		if ok {
+			result = append(result, SearchResult{Note: note, Score: best.Score, Positions: best.Positions})
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+
+		return NoteTime(result[i].Note).After(NoteTime(result[j].Note))
+	})
+
+	return result, nil
+}
+
+func hasType(note NoteHeader, noteTypes []NoteType) bool {
+	for _, t := range noteTypes {
+		if note.Type == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runePositions converts the byte range start:end of text into rune indexes.
+func runePositions(text string, start int, end int) []int {
+	result := make([]int, 0)
+	idx := 0
+
+	for i := range text {
+		if i >= start && i < end {
+			result = append(result, idx)
 		}
+		idx++
+	}
+
+	return result
+}
+
+// NoteTime returns when a note was created from its date, falling back to
+// the unix time in its id.
+func NoteTime(note NoteHeader) time.Time {
+	if t, err := time.Parse(time.RFC822, note.Date); err == nil {
+		return t
+	}
+
+	if secs, err := strconv.ParseInt(note.Id, 10, 64); err == nil {
+		return time.Unix(secs, 0)
+	}
+
+	return time.Time{}
+}
+
+func RefreshNotes() error {
+	AllNotes = make([]NoteHeader, 0)
+	DuplicateNotes = nil
+	notePaths = make(map[string]string)
+	linkIndex = nil
+
+	if _, err := os.Stat(NoteDirectory); err != nil {
+		return err
+	}
 
-		id := strings.ReplaceAll(file.Name(), ".md", "")
-		header, err := GetHeaderFromFile(id)
+	skipped, err := notefile.Walk(NoteDirectory, func(path string, id string) {
+		header, err := readHeader(path, id)
 
 		if err != nil {
-			continue
+			return
 		}
 
+		notePaths[id] = path
 		AllNotes = append(AllNotes, header)
+	})
+
+	DuplicateNotes = skipped
+	return err
+}
 
+// NotesStamp sums up the paths, sizes and modification times of the note
+// files. It changes whenever a note is added, removed or edited.
+func NotesStamp() (string, error) {
+	if _, err := os.Stat(NoteDirectory); err != nil {
+		return "", err
 	}
 
-	return nil
+	hash := sha256.New()
+
+	_, err := notefile.Walk(NoteDirectory, func(path string, id string) {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(hash, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+	})
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), err
 }
 
 func RefreshNote(id string) error {
@@ -246,87 +396,310 @@ func RefreshNote(id string) error {
 
 	newNote, err := GetHeaderFromFile(id)
 	AllNotes[idx] = newNote
+	linkIndex = nil
 
 	return err
 }
 
 func NewNote(title string, noteType NoteType) (NoteData, error) {
 	curTime := time.Now().UTC()
-	atomicId := fmt.Sprintf("%v", curTime.Unix())
-	path := filepath.Join(NoteDirectory, fmt.Sprintf("%v.md", atomicId))
+	atomicId := NewNoteId(curTime)
+
+	// Notes made within the same second would share an id, later ones take the
+	// next free second.
+	for next := curTime; noteExists(atomicId) && next.Sub(curTime) < time.Hour; {
+		next = next.Add(time.Second)
+		atomicId = NewNoteId(next)
+	}
 
-	header := NoteHeader{Title: title, Id: atomicId, Filename: path, Date: curTime.Format(time.RFC822)}
-	result := NoteData{Header: header, RawText: "", FormatedText: "", Links: make([]NoteLink, 0)}
+	dir := NoteDirectory
+
+	if cfg, ok := LookupType(noteType); ok && cfg.Folder != "" {
+		dir = filepath.Join(NoteDirectory, cfg.Folder)
+
+		if err := os.MkdirAll(dir, 0760); err != nil {
+			return NoteData{}, err
+		}
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%v.md", atomicId))
+
+	header := NoteHeader{Title: title, Id: atomicId, Filename: path, Date: curTime.Format(time.RFC822), Type: noteType, State: Workflow(noteType)[0]}
+	result := NoteData{Header: header, RawText: "", FormatedText: "", Links: make([]*markdown.Link, 0)}
+
+	if cfg, ok := LookupType(noteType); ok {
+		if cfg.State != UnknownState {
+			result.Header.State = cfg.State
+		}
+
+		result.RawText = noteTemplate(cfg, title)
+	}
 
 	err := SaveNoteData(result)
 
-	AllNotes = append(AllNotes, header)
+	AllNotes = append(AllNotes, result.Header)
+
+	if notePaths != nil {
+		notePaths[atomicId] = path
+	}
 
 	return result, err
 }
 
-func ExtractLinks(note *NoteData) {
-	link := regexp.MustCompile(`\[(.+?)\]\((.+?)\)`)
-	linkMatches := link.FindAllStringSubmatch(note.RawText, -1)
+// noteTemplate returns the body for a new note of the type. Template paths are
+// relative to the notes directory and {{title}} is replaced with the title. A
+// template that can't be read gives an empty note.
+func noteTemplate(cfg TypeConfig, title string) string {
+	if cfg.Template == "" {
+		return ""
+	}
+
+	path := cfg.Template
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(NoteDirectory, path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ReplaceAll(string(data), "{{title}}", title)
+}
 
-	id := 0
-	for i := range linkMatches {
-		typ := LinkUrl
+// ResolveNotes finds the notes a link target refers to. An id match wins, then
+// exact title or alias matches and then the same ignoring case. More than one
+// result means the target is ambiguous.
+func ResolveNotes(target string) []NoteHeader {
+	target = strings.TrimSpace(target)
 
-		if strings.HasPrefix(linkMatches[i][2], "zk:") {
-			typ = LinkNote
+	if target == "" {
+		return []NoteHeader{}
+	}
+
+	if vault, id, ok := SplitVaultLink(target); ok {
+		if vault != Settings.Vault {
+			if header, ok := VaultNote(vault, id); ok {
+				return []NoteHeader{header}
+			}
+
+			return []NoteHeader{}
 		}
 
-		if strings.HasPrefix(linkMatches[i][2], "zka:") {
-			typ = LinkAttachment
+		target = id
+	}
+
+	for _, note := range AllNotes {
+		if note.Id == target {
+			return []NoteHeader{note}
 		}
+	}
 
-		if strings.HasPrefix(linkMatches[i][2], "rp:") {
-			typ = LinkReport
+	matchers := []func(string) bool{
+		func(name string) bool { return name == target },
+		func(name string) bool { return strings.EqualFold(name, target) },
+	}
+
+	for _, match := range matchers {
+		if result := matchNotes(match); len(result) > 0 {
+			return result
 		}
+	}
 
-		lnk := NoteLink{Title: linkMatches[i][1], Path: linkMatches[i][2], Type: typ, Id: id}
+	return []NoteHeader{}
+}
+
+// SuggestNotes finds the notes to offer for a link that doesn't point at a
+// single note. It falls back to a fuzzy match on titles and aliases.
+func SuggestNotes(target string) []NoteHeader {
+	if result := ResolveNotes(target); len(result) > 0 {
+		return result
+	}
 
-		note.Links = append(note.Links, lnk)
-		id += 1
+	target = strings.TrimSpace(target)
+
+	if target == "" {
+		return []NoteHeader{}
 	}
 
+	return matchNotes(func(name string) bool {
+		_, ok := fuzzy.Find(target, name)
+		return ok
+	})
 }
 
-func GetNoteData(header NoteHeader) (NoteData, error) {
-	result := NoteData{Header: header, RawText: "", FormatedText: "", Links: make([]NoteLink, 0)}
+// matchNotes returns the notes whose title or one of whose aliases match.
+func matchNotes(match func(name string) bool) []NoteHeader {
+	result := make([]NoteHeader, 0)
 
-	byteData, err := ioutil.ReadFile(header.Filename)
+	for _, note := range AllNotes {
+		if match(note.Title) {
+			result = append(result, note)
+			continue
+		}
 
-	if err != nil {
-		return result, err
+		for _, alias := range note.Aliases {
+			if match(alias) {
+				result = append(result, note)
+				break
+			}
+		}
 	}
 
-	text := string(byteData)
+	return result
+}
+
+// VaultNote reads the header of a note in another vault. Its id keeps the
+// vault name so links to it stay pointed at that vault.
+func VaultNote(vault string, id string) (NoteHeader, bool) {
+	dir := expandHome(Settings.Vaults[vault])
+	path := filepath.Join(dir, id+".md")
+
+	if _, err := os.Stat(path); err != nil {
+		path = ""
+		notefile.Walk(dir, func(p string, noteId string) {
+			if noteId == id && path == "" {
+				path = p
+			}
+		})
+	}
 
-	index := 0
-	remain := 2
-	currentCount := 0
+	header, err := readHeader(path, vault+"/"+id)
 
-	for i, c := range text {
-		if c == '-' {
-			currentCount += 1
-		} else {
-			currentCount = 0
+	return header, err == nil
+}
+
+// ResolveNote finds the single note a link target refers to.
+func ResolveNote(target string) (NoteHeader, bool) {
+	notes := ResolveNotes(target)
+
+	if len(notes) != 1 {
+		return NoteHeader{}, false
+	}
+
+	return notes[0], true
+}
+
+// ResolveNoteId is ResolveNote shaped for markdown.ResolveWikiLinks. Ambiguous
+// targets stay unresolved, following them offers a picker.
+func ResolveNoteId(target string) (string, bool) {
+	header, ok := ResolveNote(target)
+	return header.Id, ok
+}
+
+// ExtractLinks fills in the links of the note in the same order as the
+// rendered link regions.
+func ExtractLinks(note *NoteData) {
+	note.Links = markdown.ExtractLinks(note.RawText, ResolveNoteId)
+
+	// zk: links may use a title or alias instead of the id.
+	for _, lnk := range note.Links {
+		if lnk.Type != markdown.LNK_ZK || lnk.Wiki {
+			continue
 		}
 
-		if currentCount == 3 {
-			currentCount = 0
-			remain -= 1
+		if header, ok := ResolveNote(lnk.Target); ok {
+			lnk.Target = header.Id
 		}
+	}
+}
 
-		if remain == 0 {
-			index = i + 2 // Also eat new line
-			break
+// LinkIndex returns the ids of the notes each note links to.
+func LinkIndex() map[string][]string {
+	if linkIndex != nil {
+		return linkIndex
+	}
+
+	linkIndex = make(map[string][]string)
+
+	for _, header := range AllNotes {
+		note, err := GetNoteData(header)
+
+		if err != nil {
+			continue
 		}
+
+		targets := make([]string, 0)
+
+		for _, lnk := range note.Links {
+			if lnk.Type == markdown.LNK_ZK {
+				targets = append(targets, lnk.Target)
+			}
+		}
+
+		linkIndex[header.Id] = targets
+	}
+
+	return linkIndex
+}
+
+// Backlinks returns every note that links to the note with the given id.
+func Backlinks(id string) []NoteHeader {
+	result := make([]NoteHeader, 0)
+	index := LinkIndex()
+
+	for _, header := range AllNotes {
+		for _, target := range index[header.Id] {
+			if target == id {
+				result = append(result, header)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// BacklinkCounts returns how many notes link to each note id.
+func BacklinkCounts() map[string]int {
+	result := make(map[string]int)
+
+	for _, targets := range LinkIndex() {
+		seen := make(map[string]bool)
+
+		for _, target := range targets {
+			if !seen[target] {
+				seen[target] = true
+				result[target]++
+			}
+		}
+	}
+
+	return result
+}
+
+// LoadEmbed parses the note with the given id for embedding in another note.
+func LoadEmbed(id string) (*markdown.Document, string, bool) {
+	header, ok := ResolveNote(id)
+
+	if !ok {
+		return nil, "", false
+	}
+
+	note, err := GetNoteData(header)
+
+	if err != nil {
+		return nil, "", false
 	}
 
-	text = text[index:]
+	doc := markdown.Parse(note.RawText)
+	markdown.ResolveWikiLinks(doc, ResolveNoteId)
+
+	return doc, header.Title, true
+}
+
+func GetNoteData(header NoteHeader) (NoteData, error) {
+	result := NoteData{Header: header, RawText: "", FormatedText: "", Links: make([]*markdown.Link, 0)}
+
+	byteData, err := ioutil.ReadFile(header.Filename)
+
+	if err != nil {
+		return result, err
+	}
+
+	_, text, _ := notefile.Split(string(byteData))
 
 	result.RawText = text
 	ExtractLinks(&result)
@@ -335,86 +708,193 @@ func GetNoteData(header NoteHeader) (NoteData, error) {
 }
 
 func SaveNoteData(note NoteData) error {
-	noteTypeText := "zettle"
-	statusText := "new"
+	noteType := note.Header.Type
 
-	if note.Header.Type == ZettleNote {
-		noteTypeText = "zettle"
+	if noteType == UnknownNote {
+		noteType = Settings.DefaultType
 	}
 
-	if note.Header.Type == MapNote {
-		noteTypeText = "map"
-	}
+	state := note.Header.State
 
-	if note.Header.Type == LiteratureNote {
-		noteTypeText = "literature"
+	if state == UnknownState {
+		state = Workflow(noteType)[0]
 	}
 
-	if note.Header.Type == FleetingNote {
-		noteTypeText = "fleeting"
-	}
+	data, err := notefile.Format(notefile.Header{
+		Title:   note.Header.Title,
+		Date:    note.Header.Date,
+		Type:    string(noteType),
+		State:   string(state),
+		Tags:    note.Header.Tags,
+		Aliases: note.Header.Aliases,
+	}, note.RawText)
 
-	if note.Header.State == NewState {
-		statusText = "new"
+	if err != nil {
+		return err
 	}
 
-	if note.Header.State == DoneState {
-		statusText = "done"
-	}
+	// Write to a temporary file first so a failed write can't lose the note.
+	tmpName := note.Header.Filename + ".tmp"
 
-	if note.Header.State == GreenState {
-		statusText = "green"
+	if err := ioutil.WriteFile(tmpName, data, 0660); err != nil {
+		os.Remove(tmpName)
+		return err
 	}
 
-	file, err := os.Create(note.Header.Filename)
+	linkIndex = nil
+
+	return os.Rename(tmpName, note.Header.Filename)
+
+}
+
+// ToggleTask checks or unchecks the task with the given index in the note and
+// saves the note.
+func ToggleTask(note *NoteData, index int) error {
+	text, err := markdown.ToggleTask(note.RawText, index)
 
 	if err != nil {
 		return err
 	}
 
-	writer := bufio.NewWriter(file)
-	writer.WriteString("---\n")
-	writer.WriteString(fmt.Sprintf("Title: %s\n", note.Header.Title))
-	writer.WriteString(fmt.Sprintf("Date: %s\n", note.Header.Date))
-	writer.WriteString(fmt.Sprintf("Type: %s\n", noteTypeText))
-	writer.WriteString(fmt.Sprintf("Status: %s\n", statusText))
-	writer.WriteString("---\n")
-	writer.WriteString(note.RawText)
-	writer.Flush()
-	file.Close()
+	note.RawText = text
+	return SaveNoteData(*note)
+}
 
-	return nil
+func AttachFile(path string) string {
+	srcFile, err := os.Open(path)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer srcFile.Close()
+
+	id, err := SaveAttachment(filepath.Ext(path), srcFile)
+
+	if err != nil {
+		panic(err)
+	}
 
+	return id
 }
 
-func AttachFile(path string) string {
-	ext := filepath.Ext(path)
+// SaveAttachment copies data into the attachment folder as a new file with the
+// given extension and returns its id, the file name without the extension.
+func SaveAttachment(ext string, data io.Reader) (string, error) {
 	curTime := time.Now().UTC()
 	atomicId := fmt.Sprintf("%v", curTime.Unix())
+	path := filepath.Join(NoteDirectory, ".attachments", atomicId+ext)
+
+	// Don't overwrite an attachment added within the same second.
+	for n := curTime.Unix() + 1; fileExists(path); n++ {
+		atomicId = fmt.Sprintf("%v", n)
+		path = filepath.Join(NoteDirectory, ".attachments", atomicId+ext)
+	}
 
-	dstFile, err := os.Create(filepath.Join(NoteDirectory, ".attachments", atomicId+ext))
+	dstFile, err := os.Create(path)
 
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
-	defer dstFile.Close()
+	if _, err := io.Copy(dstFile, data); err != nil {
+		dstFile.Close()
+		os.Remove(path)
+		return "", err
+	}
 
-	srcFile, err := os.Open(path)
+	return atomicId, dstFile.Close()
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
 
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	defer srcFile.Close()
+	defer in.Close()
 
-	io.Copy(dstFile, srcFile)
+	out, err := os.Create(dst)
 
-	return atomicId
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// noteExists reports if a note with the given id has been scanned or has a
+// file at the top of the notes directory.
+func noteExists(id string) bool {
+	if _, ok := notePaths[id]; ok {
+		return true
+	}
+
+	return fileExists(NotePath(id))
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 func RemoveNote(id string) {
-	os.Remove(filepath.Join(NoteDirectory, id+".md"))
+	os.Remove(NotePath(id))
+	forgetNote(id)
+}
+
+// TrashNote moves a note into the .trash directory instead of deleting it.
+func TrashNote(id string) error {
+	trash := filepath.Join(NoteDirectory, ".trash")
+
+	if err := os.MkdirAll(trash, 0760); err != nil {
+		return err
+	}
+
+	path := NotePath(id)
+
+	if err := os.Rename(path, filepath.Join(trash, filepath.Base(path))); err != nil {
+		return err
+	}
+
+	forgetNote(id)
+	return nil
+}
+
+// UpdateNoteHeader applies update to the header of a note and saves it.
+func UpdateNoteHeader(id string, update func(header *NoteHeader)) error {
+	header, err := GetHeaderFromFile(id)
+
+	if err != nil {
+		return err
+	}
+
+	note, err := GetNoteData(header)
+
+	if err != nil {
+		return err
+	}
+
+	update(&note.Header)
+
+	if err := SaveNoteData(note); err != nil {
+		return err
+	}
+
+	return RefreshNote(id)
+}
+
+// forgetNote drops a note from AllNotes.
+func forgetNote(id string) {
+	linkIndex = nil
+	delete(notePaths, id)
 
 	idx := -1
 	for i := range AllNotes {
@@ -457,17 +937,84 @@ func DoDataSync() bool {
 	os.Chdir(NoteDirectory)
 	defer os.Chdir(pwd)
 
+	if Settings.Sync.Pull {
+		if RunCommand("git", []string{"pull"}) != 0 {
+			return false
+		}
+	}
+
 	if RunCommand("git", []string{"add", "."}) != 0 {
 		return false
 	}
 
-	if RunCommand("git", []string{"commit", "-m", "Syncing data"}) != 0 {
+	if RunCommand("git", []string{"commit", "-m", Settings.Sync.Message}) != 0 {
 		return false
 	}
 
-	if RunCommand("git", []string{"push"}) != 0 {
-		return false
+	if Settings.Sync.Push {
+		if RunCommand("git", []string{"push"}) != 0 {
+			return false
+		}
 	}
 
 	return true
 }
+
+// AddTag adds a tag to a header unless it already has it.
+func AddTag(header *NoteHeader, tag string) {
+	for _, t := range header.Tags {
+		if t == tag {
+			return
+		}
+	}
+
+	header.Tags = append(header.Tags, tag)
+}
+
+// RemoveTag removes a tag from a header.
+func RemoveTag(header *NoteHeader, tag string) {
+	tags := make([]string, 0, len(header.Tags))
+
+	for _, t := range header.Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+
+	header.Tags = tags
+}
+
+// ExportNote writes a note to path as markdown. Notes are copied with their
+// header and reports are written as rendered.
+func ExportNote(note NoteData, path string) error {
+	if note.Header.Filename == "" {
+		return ioutil.WriteFile(path, []byte(note.RawText), 0660)
+	}
+
+	data, err := ioutil.ReadFile(note.Header.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0660)
+}
+
+// Attachments lists the files in the attachment folder.
+func Attachments() ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(NoteDirectory, ".attachments"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(files))
+
+	for _, f := range files {
+		if !f.IsDir() {
+			result = append(result, f.Name())
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeNote writes a note file under the notes directory.
+func writeNote(t *testing.T, name string, text string) string {
+	path := filepath.Join(NoteDirectory, filepath.FromSlash(name))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0760); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(text), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestDuplicateNamesKeepLinks(t *testing.T) {
+	useVault(t)
+
+	original := writeNote(t, "x/foo.md", "---\nTitle: Foo\nDate: 01 Jan 21 10:00 UTC\n---\n")
+	writeNote(t, "a.md", "---\nTitle: A\nDate: 01 Jan 21 11:00 UTC\n---\n[[foo]] and [foo](zk:foo)\n")
+
+	check := func() {
+		if err := RefreshNotes(); err != nil {
+			t.Fatal(err)
+		}
+
+		if header, ok := ResolveNote("foo"); !ok || header.Filename != original {
+			t.Errorf("Expected foo to stay %s, got %+v", original, header)
+		}
+
+		if links := LinkIndex()["a"]; !reflect.DeepEqual(links, []string{"foo", "foo"}) {
+			t.Errorf("Expected a to link to foo, got %v", links)
+		}
+	}
+
+	check()
+
+	writeNote(t, "y/foo.md", "---\nTitle: Other Foo\nDate: 02 Jan 21 10:00 UTC\n---\n")
+	check()
+
+	if _, ok := findNote("y/foo"); !ok {
+		t.Errorf("Expected the newer foo to be named by its path")
+	}
+
+	top := writeNote(t, "foo.md", "---\nTitle: Top Foo\nDate: 03 Jan 21 10:00 UTC\n---\n")
+	check()
+
+	if !reflect.DeepEqual(DuplicateNotes, []string{top}) {
+		t.Errorf("Expected %s to be reported, got %v", top, DuplicateNotes)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wiltaylor/kn/markdown"
+	"gopkg.in/yaml.v3"
+)
+
+var anchorPattern = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// ExportCommand runs the kn export subcommands.
+func ExportCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: kn export bundle <id> [--depth N] [--out dir]")
+		fmt.Fprintln(os.Stderr, "       kn export json [--lines] [--out file]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "bundle":
+		flags := flag.NewFlagSet("export bundle", flag.ExitOnError)
+		depth := flags.Int("depth", 1, "How many links deep to follow from the root note")
+		out := flags.String("out", "", "Folder to write the bundle to, bundle-<id> by default")
+		positional := parseArgs(flags, args[1:])
+
+		if len(positional) != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: kn export bundle <id> [--depth N] [--out dir]")
+			os.Exit(1)
+		}
+
+		RefreshNotes()
+		path, err := ExportBundle(positional[0], *depth, *out)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println(path)
+	case "json":
+		flags := flag.NewFlagSet("export json", flag.ExitOnError)
+		lines := flags.Bool("lines", false, "Write JSON Lines, one note per line")
+		out := flags.String("out", "", "File to write to instead of stdout")
+		parseArgs(flags, args[1:])
+
+		RefreshNotes()
+		w := os.Stdout
+
+		if *out != "" {
+			file, err := os.Create(*out)
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+				os.Exit(1)
+			}
+
+			defer file.Close()
+			w = file
+		}
+
+		if err := ExportJSON(w, *lines); err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown export %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// BundleNotes returns the notes reachable from the root note by following up
+// to depth links, in the order they are first reached.
+func BundleNotes(root NoteHeader, depth int) []NoteHeader {
+	index := LinkIndex()
+	result := []NoteHeader{root}
+	seen := map[string]bool{root.Id: true}
+	level := []NoteHeader{root}
+
+	for d := 0; d < depth && len(level) > 0; d++ {
+		next := make([]NoteHeader, 0)
+
+		for _, note := range level {
+			for _, target := range index[note.Id] {
+				header, ok := ResolveNote(target)
+
+				if !ok || seen[header.Id] {
+					continue
+				}
+
+				seen[header.Id] = true
+				result = append(result, header)
+				next = append(next, header)
+			}
+		}
+
+		level = next
+	}
+
+	return result
+}
+
+// noteAnchor is the heading id a note gets in a bundle.
+func noteAnchor(id string) string {
+	return "note-" + strings.Trim(anchorPattern.ReplaceAllString(id, "-"), "-")
+}
+
+// ExportBundle writes the note with the given id and the notes it links to
+// into one markdown document for pandoc. Each note becomes a section with its
+// headings moved down a level, links between bundled notes point at the
+// sections and linked attachments are copied into an attachments folder next
+// to the document. It returns the path of the document.
+func ExportBundle(id string, depth int, out string) (string, error) {
+	root, ok := ResolveNote(id)
+
+	if !ok {
+		return "", fmt.Errorf("no note %s", id)
+	}
+
+	if out == "" {
+		out = "bundle-" + anchorPattern.ReplaceAllString(root.Id, "-")
+	}
+
+	notes := BundleNotes(root, depth)
+	included := make(map[string]bool)
+
+	for _, note := range notes {
+		included[note.Id] = true
+	}
+
+	if err := os.MkdirAll(filepath.Join(out, "attachments"), 0760); err != nil {
+		return "", err
+	}
+
+	meta, err := yaml.Marshal(map[string]string{"title": root.Title, "date": time.Now().Format("2006-01-02")})
+
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("---\n" + string(meta) + "---\n")
+
+	for _, header := range notes {
+		note, err := GetNoteData(header)
+
+		if err != nil {
+			return "", err
+		}
+
+		body := markdown.ReplaceLinks(demoteHeadings(note.RawText), func(l *markdown.Link, text string) (string, bool) {
+			return bundleLink(l, text, included, out)
+		})
+
+		sb.WriteString(fmt.Sprintf("\n# %s {#%s}\n\n", header.Title, noteAnchor(header.Id)))
+		sb.WriteString(strings.TrimSpace(body) + "\n")
+	}
+
+	path := filepath.Join(out, "bundle.md")
+
+	if err := ioutil.WriteFile(path, []byte(sb.String()), 0660); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// demoteHeadings moves each heading of a note down a level so the note fits
+// under its section. Headings are never moved past level 6.
+func demoteHeadings(text string) string {
+	headings := markdown.Parse(text).Headings()
+
+	for i := len(headings) - 1; i >= 0; i-- {
+		if h := headings[i]; h.Level < 6 {
+			offset := h.Pos().Offset
+			text = text[:offset] + "#" + text[offset:]
+		}
+	}
+
+	return text
+}
+
+// bundleLink rewrites a link for a bundle. Links to bundled notes point at
+// their section and other note and report links become their title.
+// Attachments are copied next to the bundle.
+func bundleLink(l *markdown.Link, text string, included map[string]bool, out string) (string, bool) {
+	switch {
+	case l.Wiki:
+		title := l.Target
+
+		// A wikilink without a | shows the title of the note it points at.
+		if strings.Contains(text, "|") {
+			title = l.Title
+		}
+
+		if header, ok := ResolveNote(l.Target); ok && included[header.Id] {
+			if !strings.Contains(text, "|") {
+				title = header.Title
+			}
+
+			return fmt.Sprintf("[%s](#%s)", title, noteAnchor(header.Id)), true
+		}
+
+		return title, true
+	case l.Type == markdown.LNK_ZK:
+		if header, ok := ResolveNote(l.Target); ok && included[header.Id] {
+			return fmt.Sprintf("[%s](#%s)", l.Title, noteAnchor(header.Id)), true
+		}
+
+		return l.Title, true
+	case l.Type == markdown.LNK_REPORT:
+		return l.Title, true
+	case l.Type == markdown.LNK_ZKA, l.Type == markdown.LNK_IMAGE && strings.HasPrefix(l.Target, "zka:"):
+		name := filepath.Base(strings.TrimPrefix(l.Target, "zka:"))
+
+		if err := copyFile(filepath.Join(NoteDirectory, ".attachments", name), filepath.Join(out, "attachments", name)); err != nil {
+			return l.Title, true
+		}
+
+		if l.Type == markdown.LNK_IMAGE {
+			return fmt.Sprintf("![%s](attachments/%s)", l.Title, name), true
+		}
+
+		return fmt.Sprintf("[%s](attachments/%s)", l.Title, name), true
+	}
+
+	return "", false
+}
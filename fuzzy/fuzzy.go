@@ -0,0 +1,128 @@
+// Package fuzzy scores how well a pattern matches a piece of text in the style
+// of fzf. Every character of the pattern has to appear in the text in order,
+// matches at the start of words and runs of consecutive characters score
+// higher and gaps between matched characters cost points.
+package fuzzy
+
+import (
+	"unicode"
+)
+
+const (
+	scoreMatch       = 16
+	bonusBoundary    = 8
+	bonusFirst       = 8
+	bonusConsecutive = 6
+	penaltyGapStart  = 3
+	penaltyGapExtend = 1
+	noMatch          = -1 << 30
+)
+
+// Match is the result of matching a pattern. Positions holds the rune indexes
+// of the matched characters in the text.
+type Match struct {
+	Score     int
+	Positions []int
+}
+
+// Find matches pattern against text ignoring case. It returns false when the
+// text does not contain every character of the pattern in order. An empty
+// pattern matches everything with a score of 0.
+func Find(pattern string, text string) (Match, bool) {
+	p := []rune(pattern)
+	t := []rune(text)
+
+	if len(p) == 0 {
+		return Match{Positions: []int{}}, true
+	}
+
+	if len(p) > len(t) {
+		return Match{}, false
+	}
+
+	// score[i][j] is the best score for matching p[:i+1] with p[i] at t[j].
+	score := make([][]int, len(p))
+	prev := make([][]int, len(p))
+
+	for i := range p {
+		score[i] = make([]int, len(t))
+		prev[i] = make([]int, len(t))
+		pc := unicode.ToLower(p[i])
+
+		for j := range t {
+			score[i][j] = noMatch
+			prev[i][j] = -1
+
+			if unicode.ToLower(t[j]) != pc {
+				continue
+			}
+
+			bonus := scoreMatch + boundary(t, j)
+
+			if i == 0 {
+				score[i][j] = bonus
+				continue
+			}
+
+			for k := i - 1; k < j; k++ {
+				if score[i-1][k] == noMatch {
+					continue
+				}
+
+				s := score[i-1][k] + bonus
+
+				if k == j-1 {
+					s += bonusConsecutive
+				} else {
+					s -= penaltyGapStart + penaltyGapExtend*(j-k-2)
+				}
+
+				if s > score[i][j] {
+					score[i][j] = s
+					prev[i][j] = k
+				}
+			}
+		}
+	}
+
+	last := len(p) - 1
+	best := -1
+
+	for j := range t {
+		if score[last][j] != noMatch && (best == -1 || score[last][j] > score[last][best]) {
+			best = j
+		}
+	}
+
+	if best == -1 {
+		return Match{}, false
+	}
+
+	result := Match{Score: score[last][best], Positions: make([]int, len(p))}
+
+	for i, j := last, best; i >= 0; i-- {
+		result.Positions[i] = j
+		j = prev[i][j]
+	}
+
+	return result, true
+}
+
+// boundary gives the bonus for a match at t[j] starting a word.
+func boundary(t []rune, j int) int {
+	if j == 0 {
+		return bonusBoundary + bonusFirst
+	}
+
+	before := t[j-1]
+
+	if !unicode.IsLetter(before) && !unicode.IsDigit(before) {
+		return bonusBoundary
+	}
+
+	if unicode.IsLower(before) && unicode.IsUpper(t[j]) {
+		return bonusBoundary
+	}
+
+	return 0
+}
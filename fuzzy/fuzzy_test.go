@@ -0,0 +1,74 @@
+package fuzzy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	t.Run("Matches characters in order ignoring case", func(t *testing.T) {
+		m, ok := Find("zk", "Zettel Kasten")
+
+		if !ok {
+			t.Fatalf("Expected a match")
+		}
+
+		if !reflect.DeepEqual(m.Positions, []int{0, 7}) {
+			t.Errorf("Expected positions [0 7], got %v", m.Positions)
+		}
+	})
+
+	t.Run("Rejects out of order characters", func(t *testing.T) {
+		if _, ok := Find("kz", "zk"); ok {
+			t.Errorf("Expected no match")
+		}
+
+		if _, ok := Find("(", "some note"); ok {
+			t.Errorf("Expected no match")
+		}
+	})
+
+	t.Run("Empty pattern matches everything", func(t *testing.T) {
+		m, ok := Find("", "anything")
+
+		if !ok || m.Score != 0 {
+			t.Errorf("Expected an empty match, got %+v", m)
+		}
+	})
+
+	t.Run("Prefers word starts and consecutive runs", func(t *testing.T) {
+		cases := []struct {
+			pattern string
+			better  string
+			worse   string
+		}{
+			{pattern: "note", better: "Note taking", worse: "No tea"},
+			{pattern: "go", better: "Go tips", worse: "Algorithms"},
+			{pattern: "mn", better: "Map Note", worse: "Moon"},
+		}
+
+		for _, c := range cases {
+			b, ok := Find(c.pattern, c.better)
+			if !ok {
+				t.Fatalf("Expected %s to match %s", c.pattern, c.better)
+			}
+
+			w, ok := Find(c.pattern, c.worse)
+			if !ok {
+				t.Fatalf("Expected %s to match %s", c.pattern, c.worse)
+			}
+
+			if b.Score <= w.Score {
+				t.Errorf("Expected '%s' (%d) to beat '%s' (%d) for %s", c.better, b.Score, c.worse, w.Score, c.pattern)
+			}
+		}
+	})
+
+	t.Run("Picks the best alignment", func(t *testing.T) {
+		m, _ := Find("ab", "xaxb ab")
+
+		if !reflect.DeepEqual(m.Positions, []int{5, 6}) {
+			t.Errorf("Expected the consecutive run, got %v", m.Positions)
+		}
+	})
+}
@@ -4,7 +4,7 @@ go 1.16
 
 require (
 	github.com/gdamore/tcell/v2 v2.3.3
+	github.com/mattn/go-runewidth v0.0.10
 	github.com/rivo/tview v0.0.0-20210521091241-1fd4a5b7aab3
-	golang.design/x/clipboard v0.4.6
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
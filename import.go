@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wiltaylor/kn/importer"
+)
+
+// ImportCommand runs kn import [-n] <dir> and kn import json and prints the
+// report.
+func ImportCommand(args []string) {
+	if len(args) > 0 && args[0] == "json" {
+		ImportJSONCommand(args[1:])
+		return
+	}
+
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := flags.Bool("n", false, "Print the report without writing anything")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: kn import [-n] <dir>")
+		os.Exit(1)
+	}
+
+	RefreshNotes()
+	report, err := importer.Import(flags.Arg(0), importVault{taken: takenIds()}, *dryRun)
+
+	if !*dryRun {
+		RefreshNotes()
+	}
+
+	if report != nil {
+		fmt.Print(report.Markdown())
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ImportJSONCommand runs kn import json [--conflict policy] <file>, reading
+// stdin when the file is -.
+func ImportJSONCommand(args []string) {
+	flags := flag.NewFlagSet("import json", flag.ExitOnError)
+	policy := flags.String("conflict", ConflictFail, "What to do with notes whose id is taken: fail, skip, overwrite or rename")
+	positional := parseArgs(flags, args)
+
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: kn import json [--conflict fail|skip|overwrite|rename] <file>")
+		os.Exit(1)
+	}
+
+	r := os.Stdin
+
+	if positional[0] != "-" {
+		file, err := os.Open(positional[0])
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		defer file.Close()
+		r = file
+	}
+
+	records, err := ReadNoteRecords(r)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	RefreshNotes()
+	report, err := ImportJSON(records, *policy)
+
+	if report != nil {
+		fmt.Print(report.Text())
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// importVault imports notes into the notes directory. taken holds the ids of
+// the notes and attachments that were there before.
+type importVault struct {
+	taken map[string]bool
+}
+
+func (importVault) NewId(t time.Time) string {
+	return NewNoteId(t)
+}
+
+func (v importVault) Taken(id string) bool {
+	return v.taken[id]
+}
+
+func (importVault) DefaultType() string {
+	return string(Settings.DefaultType)
+}
+
+func (importVault) Save(note importer.Note) error {
+	dir := filepath.Join(NoteDirectory, filepath.FromSlash(note.Folder))
+
+	if err := os.MkdirAll(dir, 0760); err != nil {
+		return err
+	}
+
+	header := NoteHeader{
+		Id:       note.Id,
+		Filename: filepath.Join(dir, note.Id+".md"),
+		Title:    note.Title,
+		Date:     note.Date,
+		Type:     NoteType(note.Type),
+		State:    NoteState(note.State),
+		Tags:     note.Tags,
+		Aliases:  note.Aliases,
+	}
+
+	return SaveNoteData(NoteData{Header: header, RawText: note.Body})
+}
+
+func (importVault) Attach(path string, name string) error {
+	return copyFile(path, filepath.Join(NoteDirectory, ".attachments", name))
+}
+
+// takenIds returns the ids of every note and attachment.
+func takenIds() map[string]bool {
+	result := make(map[string]bool)
+
+	for _, note := range AllNotes {
+		result[note.Id] = true
+	}
+
+	if files, err := Attachments(); err == nil {
+		for _, f := range files {
+			result[strings.TrimSuffix(f, filepath.Ext(f))] = true
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,518 @@
+// Package importer copies a folder of markdown files, such as an Obsidian
+// vault, into a kn vault. Notes get ids and kn front matter and their links
+// are rewritten to point at the imported notes and attachments.
+package importer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wiltaylor/kn/markdown"
+	"github.com/wiltaylor/kn/notefile"
+	"gopkg.in/yaml.v3"
+)
+
+var schemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+// dateFormat is how kn writes the date of a note.
+const dateFormat = time.RFC822
+
+// Note is an imported note. Folder is where it goes under the notes directory,
+// with / between folders.
+type Note struct {
+	Id      string
+	Folder  string
+	Title   string
+	Date    string
+	Type    string
+	State   string
+	Tags    []string
+	Aliases []string
+	Body    string
+}
+
+// Vault is where notes are imported to.
+type Vault interface {
+	// NewId returns the id of a note or attachment made at t.
+	NewId(t time.Time) string
+	// Taken reports if a note or attachment in the vault has the id.
+	Taken(id string) bool
+	// DefaultType is the type of notes whose front matter doesn't name one.
+	DefaultType() string
+	// Save writes a note.
+	Save(note Note) error
+	// Attach copies the file at path into the attachment folder as name.
+	Attach(path string, name string) error
+}
+
+// Entry records where a note came from and the id it was given.
+type Entry struct {
+	Source string
+	Id     string
+	Title  string
+	Links  int
+}
+
+// Report describes what an import changed. Attachments maps the files copied
+// to their new names and Unresolved the notes to links that were left alone.
+type Report struct {
+	Source      string
+	Notes       []Entry
+	Attachments map[string]string
+	Unresolved  map[string][]string
+}
+
+// importer holds the state of one import while notes are rewritten.
+type importer struct {
+	src    string
+	vault  Vault
+	dryRun bool
+	report *Report
+	taken  map[string]bool
+	// notes and files map lower case names and paths relative to src, without
+	// the .md for notes, to the new note ids and the attachment files.
+	notes map[string]string
+	files map[string]string
+}
+
+// Import copies the markdown notes under src into the vault. Each note gets an
+// id and kn front matter, keeping the title, tags and aliases it had.
+// [[wikilinks]] and relative links to other notes become zk: links and linked
+// or embedded files are copied as attachments. Nothing is written when dryRun
+// is set.
+func Import(src string, vault Vault, dryRun bool) (*Report, error) {
+	src, err := filepath.Abs(src)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", src)
+	}
+
+	imp := &importer{
+		src:    src,
+		vault:  vault,
+		dryRun: dryRun,
+		report: &Report{Source: src, Attachments: make(map[string]string), Unresolved: make(map[string][]string)},
+		taken:  make(map[string]bool),
+		notes:  make(map[string]string),
+		files:  make(map[string]string),
+	}
+
+	sources := make([]string, 0)
+
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if info.IsDir() {
+			if path != src && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(src, path)
+		rel = filepath.ToSlash(rel)
+
+		if !strings.HasSuffix(strings.ToLower(rel), ".md") {
+			imp.addName(imp.files, rel, path)
+			return nil
+		}
+
+		id := imp.newId(info.ModTime())
+		imp.addName(imp.notes, strings.TrimSuffix(rel, filepath.Ext(rel)), id)
+		sources = append(sources, path)
+		imp.report.Notes = append(imp.report.Notes, Entry{Source: rel, Id: id})
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, path := range sources {
+		if err := imp.importNote(path, &imp.report.Notes[i]); err != nil {
+			return imp.report, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return imp.report, nil
+}
+
+// addName adds a file under both its relative path and its base name. The
+// first file wins a base name, like links in Obsidian prefer the shortest path.
+func (imp *importer) addName(names map[string]string, rel string, value string) {
+	names[strings.ToLower(rel)] = value
+
+	base := strings.ToLower(filepath.Base(rel))
+	if _, ok := names[base]; !ok {
+		names[base] = value
+	}
+}
+
+// newId returns an id for the time that neither the vault nor this import
+// uses, moving a second on until one is free.
+func (imp *importer) newId(t time.Time) string {
+	t = t.UTC()
+	id := imp.vault.NewId(t)
+
+	for imp.taken[id] || imp.vault.Taken(id) {
+		t = t.Add(time.Second)
+		id = imp.vault.NewId(t)
+	}
+
+	imp.taken[id] = true
+	return id
+}
+
+// importNote writes one note with kn front matter and its links rewritten.
+func (imp *importer) importNote(path string, entry *Entry) error {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	meta, body := splitFrontMatter(string(data))
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	note := Note{
+		Id:     entry.Id,
+		Folder: filepath.ToSlash(filepath.Dir(entry.Source)),
+		Title:  metaString(meta, "title"),
+		Date:   metaString(meta, "date"),
+		Type:   strings.ToLower(metaString(meta, "type")),
+		State:  strings.ToLower(metaString(meta, "status")),
+		Tags:   metaList(meta, "tags"),
+	}
+
+	if note.Folder == "." {
+		note.Folder = ""
+	}
+
+	if note.Title == "" {
+		note.Title = firstHeading(body)
+	}
+
+	if note.Title == "" {
+		note.Title = name
+	}
+
+	if _, err := time.Parse(dateFormat, note.Date); err != nil {
+		note.Date = info.ModTime().UTC().Format(dateFormat)
+	}
+
+	if note.Type == "" {
+		note.Type = imp.vault.DefaultType()
+	}
+
+	// Keep the file name as an alias so the note can still be found by it.
+	note.Aliases = metaList(meta, "aliases")
+	if name != note.Title && name != note.Id {
+		note.Aliases = append(note.Aliases, name)
+	}
+
+	entry.Title = note.Title
+	note.Body, entry.Links = imp.rewriteLinks(body, filepath.Dir(path), entry.Source)
+
+	if imp.dryRun {
+		return nil
+	}
+
+	return imp.vault.Save(note)
+}
+
+// rewriteLinks rewrites the links of a note body. It returns the new body and
+// the number of links changed.
+func (imp *importer) rewriteLinks(body string, dir string, source string) (string, int) {
+	count := 0
+
+	text := markdown.ReplaceLinks(body, func(l *markdown.Link, text string) (string, bool) {
+		result, ok := "", false
+
+		switch {
+		case l.Wiki:
+			result, ok = imp.rewriteWikiLink(l, text, source)
+		case l.Type == markdown.LNK_URL || l.Type == markdown.LNK_IMAGE:
+			result, ok = imp.rewriteMarkdownLink(l, text, dir, source)
+		}
+
+		if ok {
+			count++
+		}
+
+		return result, ok
+	})
+
+	return text, count
+}
+
+// rewriteWikiLink turns [[note]] into a zk: link, [[note#heading]] and
+// ![[note]] into kn wikilinks by id and links to other files into zka: links.
+func (imp *importer) rewriteWikiLink(l *markdown.Link, text string, source string) (string, bool) {
+	if l.Target == "" {
+		return "", false
+	}
+
+	embed, title, section := "", l.Target, ""
+
+	if l.Embed {
+		embed = "!"
+	}
+
+	// The title is the text after a |, without one it is the target.
+	if strings.Contains(text, "|") {
+		title = l.Title
+	}
+
+	if l.Section != "" {
+		section = "#" + l.Section
+	}
+
+	if id, ok := imp.notes[strings.ToLower(strings.TrimSuffix(l.Target, ".md"))]; ok {
+		if l.Embed || section != "" {
+			if strings.Contains(text, "|") {
+				return fmt.Sprintf("%s[[%s%s|%s]]", embed, id, section, title), true
+			}
+			return fmt.Sprintf("%s[[%s%s]]", embed, id, section), true
+		}
+
+		return fmt.Sprintf("[%s](zk:%s)", title, id), true
+	}
+
+	if file, ok := imp.files[strings.ToLower(l.Target)]; ok {
+		if name, ok := imp.attach(file); ok {
+			return fmt.Sprintf("%s[%s](zka:%s)", embed, title, name), true
+		}
+	}
+
+	imp.unresolved(source, text)
+	return "", false
+}
+
+// rewriteMarkdownLink turns relative links to notes into zk: links and
+// relative links to other files into zka: links. Urls are left alone.
+func (imp *importer) rewriteMarkdownLink(l *markdown.Link, text string, dir string, source string) (string, bool) {
+	image, target := "", strings.Trim(l.Target, "<>")
+
+	if l.Type == markdown.LNK_IMAGE {
+		image = "!"
+	}
+
+	if schemePattern.MatchString(target) || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "/") {
+		return "", false
+	}
+
+	section := ""
+	if idx := strings.Index(target, "#"); idx != -1 {
+		section = target[idx:]
+		target = target[:idx]
+	}
+
+	if decoded, err := url.PathUnescape(target); err == nil {
+		target = decoded
+	}
+
+	rel, err := filepath.Rel(imp.src, filepath.Join(dir, target))
+
+	if err != nil {
+		return "", false
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	if strings.HasSuffix(strings.ToLower(rel), ".md") {
+		if id, ok := imp.notes[strings.ToLower(strings.TrimSuffix(rel, filepath.Ext(rel)))]; ok {
+			if section != "" {
+				return fmt.Sprintf("[[%s%s|%s]]", id, section, l.Title), true
+			}
+
+			return fmt.Sprintf("[%s](zk:%s)", l.Title, id), true
+		}
+	} else if file, ok := imp.files[strings.ToLower(rel)]; ok {
+		if name, ok := imp.attach(file); ok {
+			return fmt.Sprintf("%s[%s](zka:%s)", image, l.Title, name), true
+		}
+	}
+
+	imp.unresolved(source, text)
+	return "", false
+}
+
+// unresolved notes a link of source that was left as it is.
+func (imp *importer) unresolved(source string, text string) {
+	imp.report.Unresolved[source] = append(imp.report.Unresolved[source], text)
+}
+
+// attach copies a file into the vault once and returns its new name.
+func (imp *importer) attach(path string) (string, bool) {
+	if name, ok := imp.report.Attachments[path]; ok {
+		return name, true
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return "", false
+	}
+
+	name := imp.newId(info.ModTime()) + strings.ToLower(filepath.Ext(path))
+	imp.report.Attachments[path] = name
+
+	if imp.dryRun {
+		return name, true
+	}
+
+	if err := imp.vault.Attach(path, name); err != nil {
+		delete(imp.report.Attachments, path)
+		return "", false
+	}
+
+	return name, true
+}
+
+// splitFrontMatter separates yaml front matter from the body of a note.
+// Notes without front matter, or with front matter that isn't valid yaml,
+// come back whole.
+func splitFrontMatter(text string) (map[string]interface{}, string) {
+	meta := make(map[string]interface{})
+
+	yamlText, body, ok := notefile.Split(text)
+
+	if !ok {
+		return meta, text
+	}
+
+	if err := yaml.Unmarshal([]byte(yamlText), &meta); err != nil {
+		return make(map[string]interface{}), text
+	}
+
+	return meta, body
+}
+
+// metaValue looks up a front matter key ignoring case.
+func metaValue(meta map[string]interface{}, key string) interface{} {
+	for k, v := range meta {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func metaString(meta map[string]interface{}, key string) string {
+	switch v := metaValue(meta, key).(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case time.Time:
+		return v.UTC().Format(dateFormat)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// metaList reads a front matter list, which may also be written as a comma or
+// space separated string. Tags lose a leading #.
+func metaList(meta map[string]interface{}, key string) []string {
+	result := make([]string, 0)
+	add := func(s string) {
+		s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+		if s != "" {
+			result = append(result, s)
+		}
+	}
+
+	switch v := metaValue(meta, key).(type) {
+	case string:
+		for _, s := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }) {
+			add(s)
+		}
+	case []interface{}:
+		for _, s := range v {
+			add(fmt.Sprintf("%v", s))
+		}
+	}
+
+	return result
+}
+
+// firstHeading returns the text of the first level one heading.
+func firstHeading(body string) string {
+	for _, h := range markdown.Parse(body).Headings() {
+		if h.Level == 1 {
+			return markdown.PlainText(h)
+		}
+	}
+
+	return ""
+}
+
+// Markdown writes the report as a markdown document.
+func (r *Report) Markdown() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("# Import of %s\n\n", r.Source))
+	sb.WriteString("| File | Id | Title | Links |\n|---|---|---|---|\n")
+
+	for _, note := range r.Notes {
+		sb.WriteString(fmt.Sprintf("| %s | %s | %s | %d |\n", note.Source, note.Id, note.Title, note.Links))
+	}
+
+	if len(r.Attachments) > 0 {
+		sb.WriteString("\n## Attachments\n")
+
+		paths := make([]string, 0, len(r.Attachments))
+		for path := range r.Attachments {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			rel, _ := filepath.Rel(r.Source, path)
+			sb.WriteString(fmt.Sprintf(" - %s -> zka:%s\n", filepath.ToSlash(rel), r.Attachments[path]))
+		}
+	}
+
+	if len(r.Unresolved) > 0 {
+		sb.WriteString("\n## Unresolved links\n")
+
+		sources := make([]string, 0, len(r.Unresolved))
+		for source := range r.Unresolved {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+
+		for _, source := range sources {
+			for _, link := range r.Unresolved[source] {
+				sb.WriteString(fmt.Sprintf(" - %s: `%s`\n", source, link))
+			}
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,181 @@
+package importer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeVault keeps what is imported in memory.
+type fakeVault struct {
+	taken    map[string]bool
+	notes    map[string]Note
+	attached map[string]string
+}
+
+func (f *fakeVault) NewId(t time.Time) string {
+	return fmt.Sprint(t.Unix())
+}
+
+func (f *fakeVault) Taken(id string) bool {
+	return f.taken[id]
+}
+
+func (f *fakeVault) DefaultType() string {
+	return "zettle"
+}
+
+func (f *fakeVault) Save(note Note) error {
+	f.notes[note.Id] = note
+	return nil
+}
+
+func (f *fakeVault) Attach(path string, name string) error {
+	f.attached[name] = path
+	return nil
+}
+
+func newFakeVault() *fakeVault {
+	return &fakeVault{taken: map[string]bool{"1000": true}, notes: make(map[string]Note), attached: make(map[string]string)}
+}
+
+// writeSource writes the files of a vault to import, each modified at the
+// unix time given with it.
+func writeSource(t *testing.T, files map[string]string, times map[string]int64) string {
+	dir := t.TempDir()
+
+	for name, text := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0760); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(text), 0660); err != nil {
+			t.Fatal(err)
+		}
+
+		mtime := time.Unix(times[name], 0)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestImport(t *testing.T) {
+	book := "---\ntitle: \"Book: Title\"\ndate: 2021-05-01\ntags: \"#a b\"\naliases: [x]\n---\n" +
+		"See [[Other]] and [[Other|that]] and [[Other#Part]] and ![[pic.png]]\n" +
+		"[rel](sub/Other.md) [sec](sub/Other.md#Part) ![img](<pic.png>)\n" +
+		"`[[Code]]` [[Missing]] [web](https://x) [[]]\n```\n[[Fenced]]\n```\n"
+
+	src := writeSource(t, map[string]string{
+		"Book Note.md":     book,
+		"sub/Other.md":     "# Other Heading\ntext\n",
+		"pic.png":          "png",
+		".obsidian/app.md": "skipped",
+	}, map[string]int64{"Book Note.md": 1000, "sub/Other.md": 2000, "pic.png": 3000})
+
+	vault := newFakeVault()
+	report, err := Import(src, vault, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Note{
+		"1001": {
+			Id:      "1001",
+			Title:   "Book: Title",
+			Date:    "01 May 21 00:00 UTC",
+			Type:    "zettle",
+			Tags:    []string{"a", "b"},
+			Aliases: []string{"x", "Book Note"},
+			Body: "See [Other](zk:2000) and [that](zk:2000) and [[2000#Part]] and ![pic.png](zka:3000.png)\n" +
+				"[rel](zk:2000) [[2000#Part|sec]] ![img](zka:3000.png)\n" +
+				"`[[Code]]` [[Missing]] [web](https://x) [[]]\n```\n[[Fenced]]\n```\n",
+		},
+		"2000": {
+			Id:      "2000",
+			Folder:  "sub",
+			Title:   "Other Heading",
+			Date:    time.Unix(2000, 0).UTC().Format(time.RFC822),
+			Type:    "zettle",
+			Tags:    []string{},
+			Aliases: []string{"Other"},
+			Body:    "# Other Heading\ntext\n",
+		},
+	}
+
+	for id, note := range expected {
+		if got := vault.notes[id]; !reflect.DeepEqual(note, got) {
+			t.Errorf("Expected %+v, got %+v", note, got)
+		}
+	}
+
+	if len(vault.notes) != 2 {
+		t.Errorf("Expected 2 notes, got %v", vault.notes)
+	}
+
+	if !reflect.DeepEqual(vault.attached, map[string]string{"3000.png": filepath.Join(src, "pic.png")}) {
+		t.Errorf("Expected pic.png to be attached once, got %v", vault.attached)
+	}
+
+	entries := []Entry{{Source: "Book Note.md", Id: "1001", Title: "Book: Title", Links: 7}, {Source: "sub/Other.md", Id: "2000", Title: "Other Heading"}}
+
+	if !reflect.DeepEqual(report.Notes, entries) {
+		t.Errorf("Expected %+v, got %+v", entries, report.Notes)
+	}
+
+	if !reflect.DeepEqual(report.Unresolved, map[string][]string{"Book Note.md": {"[[Missing]]"}}) {
+		t.Errorf("Unexpected unresolved links %v", report.Unresolved)
+	}
+
+	if md := report.Markdown(); !strings.Contains(md, " - pic.png -> zka:3000.png\n") || !strings.Contains(md, " - Book Note.md: `[[Missing]]`\n") {
+		t.Errorf("Unexpected report %s", md)
+	}
+
+	t.Run("dry runs write nothing", func(t *testing.T) {
+		vault := newFakeVault()
+		dry, err := Import(src, vault, true)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(vault.notes) != 0 || len(vault.attached) != 0 {
+			t.Errorf("Expected nothing written, got %v %v", vault.notes, vault.attached)
+		}
+
+		if !reflect.DeepEqual(dry, report) {
+			t.Errorf("Expected the same report as a real import, got %+v", dry)
+		}
+	})
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	cases := []struct {
+		text string
+		meta map[string]interface{}
+		body string
+	}{
+		{text: "# Plain\n", meta: map[string]interface{}{}, body: "# Plain\n"},
+		{text: "---\nTitle: x\n---\nbody\n", meta: map[string]interface{}{"Title": "x"}, body: "body\n"},
+		{text: "---\nTitle: [x\n---\nbody\n", meta: map[string]interface{}{}, body: "---\nTitle: [x\n---\nbody\n"},
+		{text: "---\nTitle: x\nbody\n", meta: map[string]interface{}{}, body: "---\nTitle: x\nbody\n"},
+	}
+
+	for _, c := range cases {
+		meta, body := splitFrontMatter(c.text)
+
+		if !reflect.DeepEqual(meta, c.meta) || body != c.body {
+			t.Errorf("Expected %v %q, got %v %q", c.meta, c.body, meta, body)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/wiltaylor/kn/markdown"
+)
+
+// Conflict policies for ImportJSON, used when a note in the dump has the id
+// of a different note in the vault.
+const (
+	ConflictFail      = "fail"
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictRename    = "rename"
+)
+
+// NoteRecord is a note in a JSON dump. Path is the file relative to the notes
+// directory.
+type NoteRecord struct {
+	Id      string       `json:"id"`
+	Path    string       `json:"path"`
+	Title   string       `json:"title"`
+	Date    string       `json:"date"`
+	Type    string       `json:"type"`
+	State   string       `json:"state"`
+	Tags    []string     `json:"tags"`
+	Aliases []string     `json:"aliases"`
+	Body    string       `json:"body"`
+	Links   []LinkRecord `json:"links"`
+}
+
+// LinkRecord is an outgoing link of a note in a JSON dump.
+type LinkRecord struct {
+	Type    string `json:"type"`
+	Target  string `json:"target"`
+	Title   string `json:"title"`
+	Section string `json:"section,omitempty"`
+	Embed   bool   `json:"embed,omitempty"`
+}
+
+// JSONImportReport lists what ImportJSON did with each note of the dump.
+type JSONImportReport struct {
+	Created     []string
+	Unchanged   []string
+	Overwritten []string
+	Skipped     []string
+	Renamed     map[string]string
+}
+
+// linkKindName names a link type for JSON dumps.
+func linkKindName(t markdown.LinkType) string {
+	for name, kind := range linkKinds {
+		if kind == t {
+			return name
+		}
+	}
+
+	switch t {
+	case markdown.LNK_WIKI:
+		return "wiki"
+	case markdown.LNK_UNRESOLVED:
+		return "unresolved"
+	case markdown.LNK_EMBED:
+		return "embed"
+	}
+
+	return "unknown"
+}
+
+// NoteRecords returns every note as a record, sorted by id.
+func NoteRecords() ([]NoteRecord, error) {
+	notes := append([]NoteHeader{}, AllNotes...)
+	sort.Slice(notes, func(i, j int) bool { return notes[i].Id < notes[j].Id })
+
+	result := make([]NoteRecord, 0, len(notes))
+
+	for _, header := range notes {
+		record, err := noteRecord(header)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", header.Filename, err)
+		}
+
+		result = append(result, record)
+	}
+
+	return result, nil
+}
+
+// headerRecord returns the record of a note without its body and links.
+func headerRecord(header NoteHeader) NoteRecord {
+	path, err := filepath.Rel(NoteDirectory, header.Filename)
+
+	if err != nil {
+		path = header.Filename
+	}
+
+	return NoteRecord{
+		Id:      header.Id,
+		Path:    filepath.ToSlash(path),
+		Title:   header.Title,
+		Date:    header.Date,
+		Type:    string(header.Type),
+		State:   string(header.State),
+		Tags:    append([]string{}, header.Tags...),
+		Aliases: append([]string{}, header.Aliases...),
+	}
+}
+
+// noteRecord reads a note into a record.
+func noteRecord(header NoteHeader) (NoteRecord, error) {
+	note, err := GetNoteData(header)
+
+	if err != nil {
+		return NoteRecord{}, err
+	}
+
+	record := headerRecord(header)
+	record.Body = note.RawText
+	record.Links = make([]LinkRecord, 0, len(note.Links))
+
+	for _, lnk := range note.Links {
+		record.Links = append(record.Links, LinkRecord{Type: linkKindName(lnk.Type), Target: lnk.Target, Title: lnk.Title, Section: lnk.Section, Embed: lnk.Embed})
+	}
+
+	return record, nil
+}
+
+// ExportJSON writes every note as a JSON array, or as JSON Lines with one note
+// per line when lines is set.
+func ExportJSON(w io.Writer, lines bool) error {
+	records, err := NoteRecords()
+
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+
+	if lines {
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// ReadNoteRecords reads a JSON array of notes or JSON Lines.
+func ReadNoteRecords(r io.Reader) ([]NoteRecord, error) {
+	data, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]NoteRecord, 0)
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &result)
+		return result, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var record NoteRecord
+
+		if err := dec.Decode(&record); err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		result = append(result, record)
+	}
+}
+
+// sameNote reports if a record holds the same note as the one in the vault.
+// A missing type or state counts as the one SaveNoteData would write.
+func sameNote(record NoteRecord, header NoteHeader) bool {
+	note, err := GetNoteData(header)
+
+	if err != nil {
+		return false
+	}
+
+	noteType, state := NoteType(record.Type), NoteState(record.State)
+
+	if noteType == UnknownNote {
+		noteType = Settings.DefaultType
+	}
+
+	if state == UnknownState {
+		state = Workflow(noteType)[0]
+	}
+
+	return record.Title == header.Title && record.Date == header.Date &&
+		noteType == header.Type && state == header.State &&
+		strings.Join(record.Tags, "\x00") == strings.Join(header.Tags, "\x00") &&
+		strings.Join(record.Aliases, "\x00") == strings.Join(header.Aliases, "\x00") &&
+		record.Body == note.RawText
+}
+
+// ImportJSON restores notes from a dump into the notes directory. Notes are
+// handled in id order and a note that matches the one in the vault is left
+// alone. A different note with the same id is handled by policy: fail writes
+// nothing and reports every conflict, skip keeps the vault's note, overwrite
+// replaces it and rename gives the dumped note the first free id of the form
+// id-N and points links in the dump at it. Links in the dump are not used,
+// they are worked out from the body again.
+func ImportJSON(records []NoteRecord, policy string) (*JSONImportReport, error) {
+	switch policy {
+	case ConflictFail, ConflictSkip, ConflictOverwrite, ConflictRename:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %s", policy)
+	}
+
+	records = append([]NoteRecord{}, records...)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Id < records[j].Id })
+
+	taken := make(map[string]bool)
+	for _, note := range AllNotes {
+		taken[note.Id] = true
+	}
+
+	for i, record := range records {
+		if record.Id == "" {
+			return nil, fmt.Errorf("note %d has no id", i)
+		}
+
+		if i > 0 && records[i-1].Id == record.Id {
+			return nil, fmt.Errorf("note %s is in the dump twice", record.Id)
+		}
+
+		if !validId(record.Id) {
+			return nil, fmt.Errorf("note %s has an id that can't be a file name", record.Id)
+		}
+
+		if record.Path != "" && (!localPath(record.Path) || !strings.HasSuffix(record.Path, ".md")) {
+			return nil, fmt.Errorf("note %s has a path outside the notes directory", record.Id)
+		}
+	}
+
+	for _, record := range records {
+		taken[record.Id] = true
+	}
+
+	report := &JSONImportReport{Renamed: make(map[string]string)}
+	write := make([]NoteRecord, 0, len(records))
+	conflicts := make([]string, 0)
+
+	for _, record := range records {
+		header, exists := findNote(record.Id)
+
+		switch {
+		case !exists:
+			report.Created = append(report.Created, record.Id)
+		case sameNote(record, header):
+			report.Unchanged = append(report.Unchanged, record.Id)
+			continue
+		case policy == ConflictFail:
+			conflicts = append(conflicts, record.Id)
+			continue
+		case policy == ConflictSkip:
+			report.Skipped = append(report.Skipped, record.Id)
+			continue
+		case policy == ConflictOverwrite:
+			report.Overwritten = append(report.Overwritten, record.Id)
+		case policy == ConflictRename:
+			id := record.Id
+			for n := 1; taken[id]; n++ {
+				id = fmt.Sprintf("%s-%d", record.Id, n)
+			}
+
+			taken[id] = true
+			report.Renamed[record.Id] = id
+		}
+
+		write = append(write, record)
+	}
+
+	if len(conflicts) > 0 {
+		return report, fmt.Errorf("notes already in the vault: %s", strings.Join(conflicts, ", "))
+	}
+
+	for _, record := range write {
+		if err := restoreNote(record, report.Renamed); err != nil {
+			return report, fmt.Errorf("%s: %w", record.Id, err)
+		}
+	}
+
+	return report, RefreshNotes()
+}
+
+// findNote returns the header of the note with the given id.
+func findNote(id string) (NoteHeader, bool) {
+	for _, note := range AllNotes {
+		if note.Id == id {
+			return note, true
+		}
+	}
+
+	return NoteHeader{}, false
+}
+
+// localPath reports if a slash separated path stays inside the directory it
+// is relative to.
+func localPath(path string) bool {
+	clean := filepath.Clean(filepath.FromSlash(path))
+
+	return !filepath.IsAbs(clean) && clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+// validId reports if an id can name a note file: a path of folders that
+// don't start with a dot, staying inside the notes directory.
+func validId(id string) bool {
+	if id == "" || strings.Contains(id, "\\") {
+		return false
+	}
+
+	for _, part := range strings.Split(id, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+
+	return localPath(id)
+}
+
+// restoreFile returns the file a record is restored to. It is the record's
+// path, with the file renamed when the note was, or the id when there is no
+// path.
+func restoreFile(record NoteRecord, id string) string {
+	if record.Path == "" {
+		return filepath.Join(NoteDirectory, filepath.FromSlash(id)+".md")
+	}
+
+	path := filepath.Join(NoteDirectory, filepath.FromSlash(record.Path))
+
+	if id != record.Id {
+		path = filepath.Join(filepath.Dir(path), filepath.Base(filepath.FromSlash(id))+".md")
+	}
+
+	return path
+}
+
+// restoreNote writes a record as a note, under its new id when it was renamed
+// and with its links to renamed notes updated.
+func restoreNote(record NoteRecord, renamed map[string]string) error {
+	id := record.Id
+	if newId, ok := renamed[id]; ok {
+		id = newId
+	}
+
+	filename := restoreFile(record, id)
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0760); err != nil {
+		return err
+	}
+
+	body := record.Body
+
+	if len(renamed) > 0 {
+		body = markdown.ReplaceLinks(body, func(l *markdown.Link, text string) (string, bool) {
+			return renameLink(l, text, renamed)
+		})
+	}
+
+	header := NoteHeader{
+		Id:       id,
+		Filename: filename,
+		Title:    record.Title,
+		Date:     record.Date,
+		Type:     NoteType(record.Type),
+		State:    NoteState(record.State),
+		Tags:     record.Tags,
+		Aliases:  record.Aliases,
+	}
+
+	// An overwritten note may have lived somewhere else.
+	old := NotePath(id)
+
+	if err := SaveNoteData(NoteData{Header: header, RawText: body}); err != nil {
+		return err
+	}
+
+	if _, exists := renamed[record.Id]; !exists && old != header.Filename {
+		os.Remove(old)
+	}
+
+	return nil
+}
+
+// renameLink points a zk: link or wikilink at the new id of a renamed note.
+func renameLink(l *markdown.Link, text string, renamed map[string]string) (string, bool) {
+	if l.Wiki {
+		id, ok := renamed[l.Target]
+
+		if !ok {
+			return "", false
+		}
+
+		result := "[[" + id
+
+		if l.Embed {
+			result = "!" + result
+		}
+
+		if l.Section != "" {
+			result += "#" + l.Section
+		}
+
+		if strings.Contains(text, "|") {
+			result += "|" + l.Title
+		}
+
+		return result + "]]", true
+	}
+
+	switch {
+	case l.Type == markdown.LNK_ZK:
+		if id, ok := renamed[l.Target]; ok {
+			return "[" + l.Title + "](zk:" + id + ")", true
+		}
+	case l.Type == markdown.LNK_IMAGE && strings.HasPrefix(l.Target, "zk:"):
+		if id, ok := renamed[l.Target[3:]]; ok {
+			return "![" + l.Title + "](zk:" + id + ")", true
+		}
+	}
+
+	return "", false
+}
+
+// Text summarises the report.
+func (r *JSONImportReport) Text() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("Created %d, unchanged %d, overwritten %d, skipped %d, renamed %d\n",
+		len(r.Created), len(r.Unchanged), len(r.Overwritten), len(r.Skipped), len(r.Renamed)))
+
+	ids := make([]string, 0, len(r.Renamed))
+	for id := range r.Renamed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		sb.WriteString(fmt.Sprintf(" - %s -> %s\n", id, r.Renamed[id]))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Key is a parsed key binding. Rune is set for printable keys and Code for the
+// rest.
+type Key struct {
+	Code tcell.Key
+	Rune rune
+}
+
+// ParseKey reads a key as written in the config: a single character or a tcell
+// key name such as Enter, F1 or Ctrl-P. Names ignore case.
+func ParseKey(text string) (Key, error) {
+	if utf8.RuneCountInString(text) == 1 {
+		r, _ := utf8.DecodeRuneInString(text)
+		return Key{Code: tcell.KeyRune, Rune: r}, nil
+	}
+
+	if strings.EqualFold(text, "Space") {
+		return Key{Code: tcell.KeyRune, Rune: ' '}, nil
+	}
+
+	for code, name := range tcell.KeyNames {
+		if strings.EqualFold(name, text) {
+			return Key{Code: code}, nil
+		}
+	}
+
+	return Key{}, fmt.Errorf("unknown key %s", text)
+}
+
+// Matches reports if the event is this key. Backspace matches both backspace
+// codes terminals send.
+func (k Key) Matches(event *tcell.EventKey) bool {
+	if k.Code == tcell.KeyRune {
+		return event.Key() == tcell.KeyRune && event.Rune() == k.Rune
+	}
+
+	if k.Code == tcell.KeyBackspace || k.Code == tcell.KeyBackspace2 {
+		return event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyBackspace2
+	}
+
+	return event.Key() == k.Code
+}
+
+// ParseBinding reads a binding from the config. Alternatives are separated by
+// commas and the keys of a sequence by spaces, a word that isn't a key name is
+// read as one key per character so ]] is ] followed by ].
+func ParseBinding(text string) ([][]Key, error) {
+	alternatives := []string{text}
+
+	if text != "," {
+		alternatives = strings.Split(text, ",")
+	}
+
+	result := make([][]Key, 0, len(alternatives))
+
+	for _, alt := range alternatives {
+		seq := make([]Key, 0)
+
+		for _, word := range strings.Fields(alt) {
+			key, err := ParseKey(word)
+
+			if err == nil {
+				seq = append(seq, key)
+				continue
+			}
+
+			for _, r := range word {
+				if r < ' ' || r > '~' {
+					return nil, err
+				}
+
+				seq = append(seq, Key{Code: tcell.KeyRune, Rune: r})
+			}
+		}
+
+		if len(seq) == 0 {
+			return nil, fmt.Errorf("empty key in %s", text)
+		}
+
+		result = append(result, seq)
+	}
+
+	return result, nil
+}
+
+// Binding ties a key sequence to an action.
+type Binding struct {
+	Keys   []Key
+	Action *Action
+}
+
+// pendingKeys holds the keys pressed so far of a sequence.
+var pendingKeys []*tcell.EventKey
+
+// KeymapBindings returns the bindings of a keymap from the settings.
+func KeymapBindings(keymap string) []Binding {
+	result := make([]Binding, 0)
+
+	for _, action := range Actions {
+		if !action.bindable(keymap) {
+			continue
+		}
+
+		sequences, err := ParseBinding(KeyText(keymap, action.Name))
+
+		if err != nil {
+			continue
+		}
+
+		for _, seq := range sequences {
+			result = append(result, Binding{Keys: seq, Action: action})
+		}
+	}
+
+	return result
+}
+
+// matches reports if the pressed keys start the binding and if they complete
+// it.
+func (b Binding) matches(pressed []*tcell.EventKey) (prefix bool, complete bool) {
+	if len(pressed) > len(b.Keys) {
+		return false, false
+	}
+
+	for i, event := range pressed {
+		if !b.Keys[i].Matches(event) {
+			return false, false
+		}
+	}
+
+	return true, len(pressed) == len(b.Keys)
+}
+
+// DispatchKey runs the action bound to the key in the first of the keymaps
+// that has one. Keys that start a sequence are held until it completes or
+// breaks, in which case the key is tried again on its own. It returns false
+// when the key isn't bound so it can go to the focused control.
+func DispatchKey(keymaps []string, event *tcell.EventKey) bool {
+	pressed := append(pendingKeys, event)
+	pendingKeys = nil
+
+	for _, keymap := range keymaps {
+		waiting := false
+
+		for _, binding := range KeymapBindings(keymap) {
+			prefix, complete := binding.matches(pressed)
+
+			if complete {
+				binding.Action.Run(nil)
+				return true
+			}
+
+			waiting = waiting || prefix
+		}
+
+		if waiting {
+			pendingKeys = pressed
+			return true
+		}
+	}
+
+	if len(pressed) > 1 {
+		return DispatchKey(keymaps, event)
+	}
+
+	return false
+}
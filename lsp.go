@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/wiltaylor/kn/lsp"
+)
+
+// lspVault gives the language server the notes of the loaded vault.
+type lspVault struct{}
+
+// LspCommand runs kn lsp, a language server on stdin and stdout.
+func LspCommand() {
+	RefreshNotes()
+
+	if err := lsp.New(lspVault{}).Run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Language server failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func lspNote(header NoteHeader) lsp.Note {
+	path, err := filepath.Abs(header.Filename)
+
+	if err != nil {
+		path = header.Filename
+	}
+
+	return lsp.Note{Id: header.Id, Title: header.Title, Type: header.Type.String(), Aliases: header.Aliases, Path: path}
+}
+
+func (lspVault) Refresh() error {
+	return RefreshNotes()
+}
+
+func (lspVault) Notes() []lsp.Note {
+	result := make([]lsp.Note, 0, len(AllNotes))
+
+	for _, header := range AllNotes {
+		result = append(result, lspNote(header))
+	}
+
+	return result
+}
+
+func (lspVault) Resolve(target string) (lsp.Note, bool) {
+	header, ok := ResolveNote(target)
+	return lspNote(header), ok
+}
+
+func (lspVault) NoteAt(path string) (lsp.Note, bool) {
+	for _, header := range AllNotes {
+		if note := lspNote(header); note.Path == filepath.Clean(path) {
+			return note, true
+		}
+	}
+
+	return lsp.Note{}, false
+}
+
+func (lspVault) Backlinks(id string) []lsp.Note {
+	result := make([]lsp.Note, 0)
+
+	for _, header := range Backlinks(id) {
+		result = append(result, lspNote(header))
+	}
+
+	return result
+}
+
+// CheckHeader reads front matter the way the notes directory is read and
+// warns about values kn would not know what to do with.
+func (lspVault) CheckHeader(yamlText string) (map[string]string, error) {
+	var header NoteHeader
+
+	if err := parseHeader(yamlText, &header); err != nil {
+		return nil, err
+	}
+
+	problems := make(map[string]string)
+
+	if header.Title == "" {
+		problems["Title"] = "Note has no title"
+	}
+
+	if header.Type == UnknownNote {
+		problems["Type"] = "Note has no type"
+	} else if _, ok := LookupType(header.Type); !ok {
+		problems["Type"] = fmt.Sprintf("Unknown type %s", header.Type)
+	}
+
+	if header.State != UnknownState && StateIndex(header.Type, header.State) == -1 {
+		problems["Status"] = fmt.Sprintf("%s is not a state of %s notes", header.State, header.Type)
+	}
+
+	return problems, nil
+}
+
+func (lspVault) AttachmentDir() string {
+	return filepath.Join(NoteDirectory, ".attachments")
+}
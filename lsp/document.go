@@ -0,0 +1,176 @@
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/wiltaylor/kn/markdown"
+)
+
+// document is a note open in the editor. The front matter is split off the
+// same way kn reads it, the body is parsed and link offsets are relative to
+// body.
+type document struct {
+	uri        string
+	text       string
+	lineStarts []int
+
+	// hasHeader is set when the note starts with a --- line and headerClosed
+	// when a second one ends the front matter.
+	hasHeader    bool
+	headerClosed bool
+	header       string
+	body         int
+	parsed       *markdown.Document
+}
+
+func newDocument(uri string, text string) *document {
+	d := &document{uri: uri, text: text, lineStarts: []int{0}}
+
+	for i, c := range text {
+		if c == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+
+	if d.line(0) == "---" {
+		d.hasHeader = true
+		d.body = len(text)
+
+		for i := 1; i < len(d.lineStarts); i++ {
+			if d.line(i) == "---" {
+				d.headerClosed = true
+				d.header = text[d.lineStarts[1]:d.lineStarts[i]]
+
+				if i+1 < len(d.lineStarts) {
+					d.body = d.lineStarts[i+1]
+				}
+				break
+			}
+		}
+	}
+
+	d.parsed = markdown.Parse(text[d.body:])
+	return d
+}
+
+// line returns the text of a line without its line break.
+func (d *document) line(n int) string {
+	if n >= len(d.lineStarts) {
+		return ""
+	}
+
+	end := len(d.text)
+	if n+1 < len(d.lineStarts) {
+		end = d.lineStarts[n+1] - 1
+	}
+
+	return d.text[d.lineStarts[n]:end]
+}
+
+// position converts a byte offset into an LSP position.
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+
+	line := sort.Search(len(d.lineStarts), func(i int) bool { return d.lineStarts[i] > offset }) - 1
+
+	return Position{Line: line, Character: utf16Len(d.text[d.lineStarts[line]:offset])}
+}
+
+// offset converts an LSP position into a byte offset, clamped to its line.
+func (d *document) offset(p Position) int {
+	if p.Line < 0 {
+		return 0
+	}
+
+	if p.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+
+	start := d.lineStarts[p.Line]
+	units := 0
+
+	for i, r := range d.line(p.Line) {
+		if units >= p.Character {
+			return start + i
+		}
+
+		units += utf16Len(string(r))
+	}
+
+	return start + len(d.line(p.Line))
+}
+
+// linkRange is the range of a link in the document.
+func (d *document) linkRange(l *markdown.Link) Range {
+	return Range{Start: d.position(d.body + l.Pos().Offset), End: d.position(d.body + l.End().Offset)}
+}
+
+// linkAt returns the link under a position.
+func (d *document) linkAt(p Position) *markdown.Link {
+	offset := d.offset(p) - d.body
+
+	for _, l := range d.parsed.Links() {
+		if offset >= l.Pos().Offset && offset < l.End().Offset {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// keyRange is the range of a key's line in the front matter, or the first
+// line when the key isn't there.
+func (d *document) keyRange(key string) Range {
+	for i := 1; i < len(d.lineStarts) && d.lineStarts[i] < d.body; i++ {
+		if strings.HasPrefix(d.line(i), key+":") {
+			return d.lineRange(i)
+		}
+	}
+
+	return d.lineRange(0)
+}
+
+func (d *document) lineRange(n int) Range {
+	return Range{Start: Position{Line: n}, End: Position{Line: n, Character: utf16Len(d.line(n))}}
+}
+
+func utf16Len(text string) int {
+	n := 0
+
+	for _, r := range text {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+
+	return n
+}
+
+// uriPath returns the file a file:// URI points at.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+
+	return filepath.FromSlash(u.Path)
+}
+
+// pathURI returns the file:// URI of a file.
+func pathURI(path string) string {
+	abs, err := filepath.Abs(path)
+
+	if err == nil {
+		path = abs
+	}
+
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,493 @@
+// Package lsp is a language server for kn notes. It speaks the Language Server
+// Protocol over stdio so any editor can complete zk: links by title, preview
+// and jump to linked notes, list backlinks and flag broken links and front
+// matter. The notes themselves come from a Vault.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wiltaylor/kn/markdown"
+)
+
+var (
+	completionPattern = regexp.MustCompile(`\]\(zk:([^()\s]*)$`)
+	yamlLinePattern   = regexp.MustCompile(`line (\d+)`)
+)
+
+// previewLines is how much of a note a hover shows.
+const previewLines = 15
+
+// Note is a note in the vault, Path is its file.
+type Note struct {
+	Id      string
+	Title   string
+	Type    string
+	Aliases []string
+	Path    string
+}
+
+// Vault is where the server finds notes.
+type Vault interface {
+	// Refresh rereads the notes so new and renamed notes are picked up.
+	Refresh() error
+	// Notes returns every note.
+	Notes() []Note
+	// Resolve returns the note a link target points at by id, title or alias.
+	Resolve(target string) (Note, bool)
+	// NoteAt returns the note stored in a file.
+	NoteAt(path string) (Note, bool)
+	// Backlinks returns the notes that link to the note with the given id.
+	Backlinks(id string) []Note
+	// CheckHeader parses the yaml of a note's front matter. It returns the
+	// parse error and any problems with fields, keyed by the field name.
+	CheckHeader(yaml string) (map[string]string, error)
+	// AttachmentDir is the folder zka: links point into.
+	AttachmentDir() string
+}
+
+// Server answers the requests of one editor. Requests are handled one at a
+// time in the order they arrive.
+type Server struct {
+	vault Vault
+	docs  map[string]*document
+	out   io.Writer
+}
+
+func New(vault Vault) *Server {
+	return &Server{vault: vault, docs: make(map[string]*document)}
+}
+
+// Run serves requests from in until the editor sends exit or closes in.
+func (s *Server) Run(in io.Reader, out io.Writer) error {
+	s.out = out
+	r := bufio.NewReader(in)
+
+	for {
+		body, err := readMessage(r)
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var req request
+
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.reply(json.RawMessage("null"), nil, &responseError{Code: codeParseError, Message: err.Error()})
+			continue
+		}
+
+		if req.Method == "exit" {
+			return nil
+		}
+
+		if len(req.Id) == 0 {
+			s.notification(req)
+			continue
+		}
+
+		result, rpcErr := s.request(req)
+
+		if err := s.reply(req.Id, result, rpcErr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(id json.RawMessage, result interface{}, rpcErr *responseError) error {
+	if rpcErr != nil {
+		return writeMessage(s.out, errorResponse{JSONRPC: "2.0", Id: id, Error: *rpcErr})
+	}
+
+	return writeMessage(s.out, response{JSONRPC: "2.0", Id: id, Result: result})
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) request(req request) (interface{}, *responseError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    1,
+					"save":      map[string]bool{"includeText": true},
+				},
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{":"}},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"referencesProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "kn"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	}
+
+	var params positionParams
+
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	switch req.Method {
+	case "textDocument/completion":
+		return s.completion(params), nil
+	case "textDocument/hover":
+		return s.hover(params), nil
+	case "textDocument/definition":
+		return s.definition(params), nil
+	case "textDocument/references":
+		return s.references(params), nil
+	}
+
+	return nil, &responseError{Code: codeMethodNotFound, Message: "unknown method " + req.Method}
+}
+
+func (s *Server) notification(req request) {
+	switch req.Method {
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(req.Params, &params) != nil {
+			return
+		}
+
+		s.vault.Refresh()
+		s.docs[params.TextDocument.URI] = newDocument(params.TextDocument.URI, params.TextDocument.Text)
+		s.publish(s.docs[params.TextDocument.URI])
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(req.Params, &params) != nil || len(params.ContentChanges) == 0 {
+			return
+		}
+
+		uri := params.TextDocument.URI
+		s.docs[uri] = newDocument(uri, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		s.publish(s.docs[uri])
+	case "textDocument/didSave":
+		var params didSaveParams
+		if json.Unmarshal(req.Params, &params) != nil {
+			return
+		}
+
+		if params.Text != nil {
+			s.docs[params.TextDocument.URI] = newDocument(params.TextDocument.URI, *params.Text)
+		}
+
+		// A saved note can fix or break links in every open note.
+		s.vault.Refresh()
+		for _, d := range s.docs {
+			s.publish(d)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if json.Unmarshal(req.Params, &params) != nil {
+			return
+		}
+
+		delete(s.docs, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	}
+}
+
+func (s *Server) publish(d *document) {
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: d.uri, Diagnostics: s.diagnostics(d)})
+}
+
+// diagnostics flags front matter kn can't read and links that go nowhere.
+func (s *Server) diagnostics(d *document) []Diagnostic {
+	result := make([]Diagnostic, 0)
+	add := func(r Range, severity int, message string) {
+		result = append(result, Diagnostic{Range: r, Severity: severity, Source: "kn", Message: message})
+	}
+
+	switch {
+	case !d.hasHeader:
+		add(d.lineRange(0), severityError, "Note has no front matter, it has to start with ---")
+	case !d.headerClosed:
+		add(d.lineRange(0), severityError, "Front matter is not closed with ---")
+	default:
+		problems, err := s.vault.CheckHeader(d.header)
+
+		if err != nil {
+			r := d.lineRange(0)
+
+			if m := yamlLinePattern.FindStringSubmatch(err.Error()); m != nil {
+				line, _ := strconv.Atoi(m[1])
+				r = d.lineRange(line)
+			}
+
+			add(r, severityError, "Bad front matter: "+err.Error())
+		}
+
+		keys := make([]string, 0, len(problems))
+		for key := range problems {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			add(d.keyRange(key), severityWarning, problems[key])
+		}
+	}
+
+	for _, l := range d.parsed.Links() {
+		switch l.Type {
+		case markdown.LNK_ZK, markdown.LNK_WIKI, markdown.LNK_EMBED:
+			if _, ok := s.vault.Resolve(l.Target); !ok {
+				add(d.linkRange(l), severityError, "No note matches "+l.Target)
+			}
+		case markdown.LNK_ZKA, markdown.LNK_IMAGE:
+			if name, ok := attachmentName(l); ok && !fileExists(filepath.Join(s.vault.AttachmentDir(), name)) {
+				add(d.linkRange(l), severityWarning, "No attachment "+name)
+			}
+		case markdown.LNK_EMPTY:
+			add(d.linkRange(l), severityWarning, "Link has no target")
+		}
+	}
+
+	return result
+}
+
+// completion offers note ids after ](zk: matched against titles and aliases.
+func (s *Server) completion(params positionParams) []CompletionItem {
+	result := make([]CompletionItem, 0)
+	d, ok := s.docs[params.TextDocument.URI]
+
+	if !ok || params.Position.Line < 0 || params.Position.Line >= len(d.lineStarts) {
+		return result
+	}
+
+	offset := d.offset(params.Position)
+	before := d.text[d.lineStarts[params.Position.Line]:offset]
+	m := completionPattern.FindStringSubmatch(before)
+
+	if m == nil {
+		return result
+	}
+
+	start := d.position(offset - len(m[1]))
+	notes := s.vault.Notes()
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].Title < notes[j].Title })
+
+	for _, n := range notes {
+		label := n.Title
+		if label == "" {
+			label = n.Id
+		}
+
+		result = append(result, CompletionItem{
+			Label:      label,
+			Kind:       completionReference,
+			Detail:     strings.TrimSpace(n.Id + " " + n.Type),
+			FilterText: strings.Join(append([]string{n.Title, n.Id}, n.Aliases...), " "),
+			TextEdit:   &TextEdit{Range: Range{Start: start, End: params.Position}, NewText: n.Id},
+		})
+	}
+
+	return result
+}
+
+// hover previews the note or names the attachment under the cursor.
+func (s *Server) hover(params positionParams) *Hover {
+	d, ok := s.docs[params.TextDocument.URI]
+
+	if !ok {
+		return nil
+	}
+
+	l := d.linkAt(params.Position)
+
+	if l == nil {
+		return nil
+	}
+
+	r := d.linkRange(l)
+
+	if name, ok := attachmentName(l); ok {
+		text := "Attachment `" + name + "`"
+
+		if !fileExists(filepath.Join(s.vault.AttachmentDir(), name)) {
+			text += " is missing"
+		}
+
+		return &Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: &r}
+	}
+
+	n, ok := s.linkedNote(l)
+
+	if !ok {
+		return nil
+	}
+
+	text := fmt.Sprintf("**%s**  \n`%s` %s", n.Title, n.Id, n.Type)
+
+	if body, err := s.noteBody(n); err == nil && body != "" {
+		text += "\n\n---\n\n" + body
+	}
+
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+// definition jumps to the linked note, at the heading of the link's section
+// when it has one, or to the linked attachment.
+func (s *Server) definition(params positionParams) []Location {
+	result := make([]Location, 0)
+	d, ok := s.docs[params.TextDocument.URI]
+
+	if !ok {
+		return result
+	}
+
+	l := d.linkAt(params.Position)
+
+	if l == nil {
+		return result
+	}
+
+	if name, ok := attachmentName(l); ok {
+		path := filepath.Join(s.vault.AttachmentDir(), name)
+
+		if fileExists(path) {
+			result = append(result, Location{URI: pathURI(path)})
+		}
+
+		return result
+	}
+
+	n, ok := s.linkedNote(l)
+
+	if !ok {
+		return result
+	}
+
+	location := Location{URI: pathURI(n.Path)}
+
+	if l.Section != "" {
+		if target, err := s.noteDocument(n); err == nil {
+			for i := range target.lineStarts {
+				if line := target.line(i); strings.HasPrefix(line, "#") && strings.TrimSpace(strings.TrimLeft(line, "#")) == l.Section {
+					location.Range = target.lineRange(i)
+					break
+				}
+			}
+		}
+	}
+
+	return append(result, location)
+}
+
+// references lists the links pointing at the note linked under the cursor,
+// or at the note being edited when the cursor isn't on a link.
+func (s *Server) references(params positionParams) []Location {
+	result := make([]Location, 0)
+	d, ok := s.docs[params.TextDocument.URI]
+
+	if !ok {
+		return result
+	}
+
+	var target Note
+
+	if l := d.linkAt(params.Position); l != nil {
+		target, ok = s.linkedNote(l)
+	} else {
+		target, ok = s.vault.NoteAt(uriPath(d.uri))
+	}
+
+	if !ok {
+		return result
+	}
+
+	for _, n := range s.vault.Backlinks(target.Id) {
+		source, err := s.noteDocument(n)
+
+		if err != nil {
+			continue
+		}
+
+		for _, l := range source.parsed.Links() {
+			if linked, ok := s.linkedNote(l); ok && linked.Id == target.Id {
+				result = append(result, Location{URI: source.uri, Range: source.linkRange(l)})
+			}
+		}
+	}
+
+	return result
+}
+
+// linkedNote returns the note a link points at.
+func (s *Server) linkedNote(l *markdown.Link) (Note, bool) {
+	switch l.Type {
+	case markdown.LNK_ZK, markdown.LNK_WIKI, markdown.LNK_EMBED:
+		return s.vault.Resolve(l.Target)
+	}
+
+	return Note{}, false
+}
+
+// noteDocument returns a note as it is open in the editor, or as it is saved.
+func (s *Server) noteDocument(n Note) (*document, error) {
+	uri := pathURI(n.Path)
+
+	if d, ok := s.docs[uri]; ok {
+		return d, nil
+	}
+
+	data, err := ioutil.ReadFile(n.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newDocument(uri, string(data)), nil
+}
+
+// noteBody returns the start of a note's body for previews.
+func (s *Server) noteBody(n Note) (string, error) {
+	d, err := s.noteDocument(n)
+
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSpace(d.text[d.body:]), "\n")
+
+	if len(lines) > previewLines {
+		lines = append(lines[:previewLines], "…")
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// attachmentName returns the file a zka: link or zka: image points at.
+func attachmentName(l *markdown.Link) (string, bool) {
+	switch {
+	case l.Type == markdown.LNK_ZKA:
+		return filepath.Base(l.Target), true
+	case l.Type == markdown.LNK_IMAGE && strings.HasPrefix(l.Target, "zka:"):
+		return filepath.Base(l.Target[4:]), true
+	}
+
+	return "", false
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
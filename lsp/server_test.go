@@ -0,0 +1,340 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeVault keeps notes in a folder. Notes link to each other through links.
+type fakeVault struct {
+	dir   string
+	notes []Note
+	links map[string][]string
+}
+
+func (f *fakeVault) Refresh() error {
+	return nil
+}
+
+func (f *fakeVault) Notes() []Note {
+	return append([]Note{}, f.notes...)
+}
+
+func (f *fakeVault) Resolve(target string) (Note, bool) {
+	for _, n := range f.notes {
+		if n.Id == target || n.Title == target {
+			return n, true
+		}
+	}
+
+	return Note{}, false
+}
+
+func (f *fakeVault) NoteAt(path string) (Note, bool) {
+	for _, n := range f.notes {
+		if n.Path == path {
+			return n, true
+		}
+	}
+
+	return Note{}, false
+}
+
+func (f *fakeVault) Backlinks(id string) []Note {
+	result := make([]Note, 0)
+
+	for _, n := range f.notes {
+		for _, target := range f.links[n.Id] {
+			if target == id {
+				result = append(result, n)
+			}
+		}
+	}
+
+	return result
+}
+
+func (f *fakeVault) CheckHeader(yaml string) (map[string]string, error) {
+	if strings.Contains(yaml, "Tags: oops") {
+		return nil, errors.New("yaml: line 2: cannot unmarshal !!str `oops` into []string")
+	}
+
+	if strings.Contains(yaml, "Type: bogus") {
+		return map[string]string{"Type": "Unknown type bogus"}, nil
+	}
+
+	return nil, nil
+}
+
+func (f *fakeVault) AttachmentDir() string {
+	return filepath.Join(f.dir, ".attachments")
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	dir := t.TempDir()
+	v := &fakeVault{dir: dir, links: map[string][]string{"2": {"1"}}}
+
+	files := map[string]string{
+		"1": "---\nTitle: First\nType: zettle\n---\n# Intro\nFirst body\n## Details\nMore\n",
+		"2": "---\nTitle: Second\nType: map\n---\nSee [one](zk:1) and [[First]]\n",
+	}
+
+	for id, text := range files {
+		path := filepath.Join(dir, id+".md")
+
+		if err := ioutil.WriteFile(path, []byte(text), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v.notes = []Note{
+		{Id: "1", Title: "First", Type: "zettle", Aliases: []string{"Uno"}, Path: filepath.Join(dir, "1.md")},
+		{Id: "2", Title: "Second", Type: "map", Path: filepath.Join(dir, "2.md")},
+	}
+
+	return v
+}
+
+// session runs the server over a list of messages and returns what it sent
+// back, keyed by request id or by method for notifications.
+func session(t *testing.T, v Vault, messages ...string) map[string][]json.RawMessage {
+	var in bytes.Buffer
+
+	for _, m := range messages {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+
+	var out bytes.Buffer
+
+	if err := New(v).Run(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(map[string][]json.RawMessage)
+	r := bufio.NewReader(&out)
+
+	for {
+		body, err := readMessage(r)
+
+		if err != nil {
+			break
+		}
+
+		var msg struct {
+			Id     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		}
+		json.Unmarshal(body, &msg)
+
+		switch {
+		case msg.Method != "":
+			result[msg.Method] = append(result[msg.Method], msg.Params)
+		case msg.Error != nil:
+			result[string(msg.Id)] = append(result[string(msg.Id)], msg.Error)
+		default:
+			result[string(msg.Id)] = append(result[string(msg.Id)], msg.Result)
+		}
+	}
+
+	return result
+}
+
+func open(uri string, text string) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/didOpen",
+		"params":  map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "text": text, "languageId": "markdown", "version": 1}},
+	})
+
+	return string(data)
+}
+
+func at(id int, method string, uri string, line int, character int) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"%s","params":{"textDocument":{"uri":"%s"},"position":{"line":%d,"character":%d}}}`, id, method, uri, line, character)
+}
+
+func TestLifecycle(t *testing.T) {
+	out := session(t, newFakeVault(t),
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"workspace/symbol","params":{}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`,
+	)
+
+	if !strings.Contains(string(out["1"][0]), `"completionProvider"`) || !strings.Contains(string(out["1"][0]), `"referencesProvider":true`) {
+		t.Errorf("Unexpected capabilities %s", out["1"][0])
+	}
+
+	if !strings.Contains(string(out["2"][0]), `-32601`) {
+		t.Errorf("Expected method not found, got %s", out["2"][0])
+	}
+
+	if string(out["3"][0]) != "null" {
+		t.Errorf("Expected a null shutdown result, got %s", out["3"][0])
+	}
+
+	if _, ok := out["4"]; ok {
+		t.Errorf("Expected the server to stop at exit")
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	v := newFakeVault(t)
+
+	cases := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{name: "clean note", text: "---\nTitle: Ok\n---\n[a](zk:1) [[Second]]\n", expected: []string{}},
+		{name: "no front matter", text: "# Just text\n", expected: []string{"0:1 Note has no front matter, it has to start with ---"}},
+		{name: "unclosed front matter", text: "---\nTitle: x\n", expected: []string{"0:1 Front matter is not closed with ---"}},
+		{name: "bad yaml", text: "---\nTitle: x\nTags: oops\n---\n", expected: []string{"2:1 Bad front matter: yaml: line 2: cannot unmarshal !!str `oops` into []string"}},
+		{name: "bad field", text: "---\nTitle: x\nType: bogus\n---\n", expected: []string{"2:2 Unknown type bogus"}},
+		{
+			name:     "broken links",
+			text:     "---\nTitle: x\n---\nSee [gone](zk:404) and [[Nowhere]]\n![pic](zka:none.png) []()\n",
+			expected: []string{"3:1 No note matches 404", "3:1 No note matches Nowhere", "4:2 No attachment none.png", "4:2 Link has no target"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out := session(t, v, open("file:///tmp/x.md", c.text))
+
+			var params publishDiagnosticsParams
+			json.Unmarshal(out["textDocument/publishDiagnostics"][0], &params)
+
+			got := make([]string, 0)
+			for _, d := range params.Diagnostics {
+				got = append(got, fmt.Sprintf("%d:%d %s", d.Range.Start.Line, d.Severity, d.Message))
+			}
+
+			if strings.Join(got, "\n") != strings.Join(c.expected, "\n") {
+				t.Errorf("Expected %v, got %v", c.expected, got)
+			}
+		})
+	}
+
+	t.Run("link ranges", func(t *testing.T) {
+		out := session(t, v, open("file:///tmp/x.md", "---\nTitle: x\n---\né [gone](zk:404)\n"))
+
+		var params publishDiagnosticsParams
+		json.Unmarshal(out["textDocument/publishDiagnostics"][0], &params)
+
+		expected := Range{Start: Position{Line: 3, Character: 2}, End: Position{Line: 3, Character: 16}}
+
+		if len(params.Diagnostics) != 1 || params.Diagnostics[0].Range != expected {
+			t.Errorf("Expected %v, got %+v", expected, params.Diagnostics)
+		}
+	})
+}
+
+func TestCompletion(t *testing.T) {
+	v := newFakeVault(t)
+	text := "---\nTitle: x\n---\nSee [it](zk:Fi\nplain text\n"
+	out := session(t, v, open("file:///tmp/x.md", text), at(1, "textDocument/completion", "file:///tmp/x.md", 3, 14), at(2, "textDocument/completion", "file:///tmp/x.md", 4, 5))
+
+	var items []CompletionItem
+	json.Unmarshal(out["1"][0], &items)
+
+	if len(items) != 2 || items[0].Label != "First" || items[0].TextEdit.NewText != "1" || !strings.Contains(items[0].FilterText, "Uno") {
+		t.Fatalf("Unexpected items %+v", items)
+	}
+
+	expected := Range{Start: Position{Line: 3, Character: 12}, End: Position{Line: 3, Character: 14}}
+
+	if items[0].TextEdit.Range != expected {
+		t.Errorf("Expected the typed text %v to be replaced, got %v", expected, items[0].TextEdit.Range)
+	}
+
+	if string(out["2"][0]) != "[]" {
+		t.Errorf("Expected no completions outside of zk: links, got %s", out["2"][0])
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	v := newFakeVault(t)
+	uri := pathURI(filepath.Join(v.dir, "2.md"))
+	text := "---\nTitle: Second\n---\nSee [one](zk:1) and [[First#Details]]\n"
+
+	out := session(t, v, open(uri, text),
+		at(1, "textDocument/hover", uri, 3, 6),
+		at(2, "textDocument/definition", uri, 3, 25),
+		at(3, "textDocument/hover", uri, 3, 1),
+		at(4, "textDocument/references", uri, 3, 6),
+	)
+
+	var hover Hover
+	json.Unmarshal(out["1"][0], &hover)
+
+	if !strings.Contains(hover.Contents.Value, "**First**") || !strings.Contains(hover.Contents.Value, "First body") || hover.Range.Start.Character != 4 {
+		t.Errorf("Unexpected hover %+v", hover)
+	}
+
+	var locations []Location
+	json.Unmarshal(out["2"][0], &locations)
+
+	if len(locations) != 1 || locations[0].URI != pathURI(filepath.Join(v.dir, "1.md")) || locations[0].Range.Start.Line != 6 {
+		t.Errorf("Expected the Details heading of note 1, got %+v", locations)
+	}
+
+	if string(out["3"][0]) != "null" {
+		t.Errorf("Expected no hover off links, got %s", out["3"][0])
+	}
+
+	json.Unmarshal(out["4"][0], &locations)
+
+	if len(locations) != 2 || locations[0].URI != uri || locations[0].Range.Start.Character != 4 || locations[1].Range.Start.Character != 20 {
+		t.Errorf("Expected both links from the open note, got %+v", locations)
+	}
+
+	t.Run("references to the current note", func(t *testing.T) {
+		uri := pathURI(filepath.Join(v.dir, "1.md"))
+		out := session(t, v, open(uri, "---\nTitle: First\n---\nbody\n"), at(1, "textDocument/references", uri, 3, 0))
+
+		var locations []Location
+		json.Unmarshal(out["1"][0], &locations)
+
+		if len(locations) != 2 || locations[0].URI != pathURI(filepath.Join(v.dir, "2.md")) {
+			t.Errorf("Expected the links in note 2, got %+v", locations)
+		}
+	})
+}
+
+func TestPositions(t *testing.T) {
+	d := newDocument("file:///x.md", "ab\n😀x\n")
+
+	cases := []struct {
+		offset   int
+		position Position
+	}{
+		{offset: 0, position: Position{Line: 0, Character: 0}},
+		{offset: 3, position: Position{Line: 1, Character: 0}},
+		{offset: 7, position: Position{Line: 1, Character: 2}},
+		{offset: 8, position: Position{Line: 1, Character: 3}},
+		{offset: 9, position: Position{Line: 2, Character: 0}},
+	}
+
+	for _, c := range cases {
+		if got := d.position(c.offset); got != c.position {
+			t.Errorf("position(%d): expected %v, got %v", c.offset, c.position, got)
+		}
+
+		if got := d.offset(c.position); got != c.offset {
+			t.Errorf("offset(%v): expected %d, got %d", c.position, c.offset, got)
+		}
+	}
+}
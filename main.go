@@ -12,12 +12,44 @@ var NoteDirectory string
 func main() {
 
 	attach := flag.String("a", "", "Copies file into attachment folder and returns the id")
+	vault := flag.String("vault", "", "Name of the vault from the config to open")
 	flag.Parse()
 
-	NoteDirectory = os.Getenv("ZKDIR")
+	if err := LoadConfig(*vault); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if flag.Arg(0) == "config" {
+		if err := PrintConfig(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print config: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	os.MkdirAll(filepath.Join(NoteDirectory, ".attachments"), 0760)
 
+	if flag.Arg(0) == "import" {
+		ImportCommand(flag.Args()[1:])
+		return
+	}
+
+	if flag.Arg(0) == "export" {
+		ExportCommand(flag.Args()[1:])
+		return
+	}
+
+	if flag.Arg(0) == "serve" {
+		ServeCommand(flag.Args()[1:])
+		return
+	}
+
+	if flag.Arg(0) == "lsp" {
+		LspCommand()
+		return
+	}
+
 	if *attach != "" {
 		id := AttachFile(*attach)
 		fmt.Println(id)
@@ -31,3 +63,21 @@ func main() {
 
 
 }
+
+// parseArgs parses flags that may come before, between or after the
+// positional arguments of a subcommand and returns the positional ones.
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	positional := make([]string, 0)
+
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+
+		if len(args) == 0 {
+			return positional
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
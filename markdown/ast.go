@@ -0,0 +1,320 @@
+package markdown
+
+import "strings"
+
+const (
+  NODE_DOCUMENT NodeKind = iota
+  NODE_HEADING
+  NODE_PARAGRAPH
+  NODE_LIST
+  NODE_LISTITEM
+  NODE_CODEBLOCK
+  NODE_TEXT
+  NODE_CODE
+  NODE_LINK
+  NODE_LINEBREAK
+  NODE_TABLE
+  NODE_TABLEROW
+  NODE_TABLECELL
+)
+
+type NodeKind int
+
+// Position is a location in the markdown source. Offset is a byte offset,
+// Line and Column start at 1.
+type Position struct {
+  Offset int
+  Line int
+  Column int
+}
+
+// Node is a single element of the markdown AST. End is the position just past
+// the last byte the node was parsed from.
+type Node interface {
+  Kind() NodeKind
+  Pos() Position
+  End() Position
+  Children() []Node
+}
+
+type node struct {
+  start Position
+  end Position
+}
+
+func (n *node) Pos() Position {
+  return n.start
+}
+
+func (n *node) End() Position {
+  return n.end
+}
+
+func (n *node) Children() []Node {
+  return nil
+}
+
+type Document struct {
+  node
+  Content []Node
+}
+
+type Heading struct {
+  node
+  Level int
+  Content []Node
+}
+
+type Paragraph struct {
+  node
+  Content []Node
+}
+
+type List struct {
+  node
+  Ordered bool
+  Level int
+  Items []*ListItem
+}
+
+// ListItem holds the inline content of the item followed by any nested lists.
+// Task items also record where their checkbox is and their order in the document.
+type ListItem struct {
+  node
+  Level int
+  Content []Node
+  Task bool
+  Checked bool
+  TaskPos Position
+  TaskIndex int
+}
+
+type CodeBlock struct {
+  node
+  Language string
+  Text string
+}
+
+// Table is a pipe table. The header row is not part of Rows.
+type Table struct {
+  node
+  Align []Alignment
+  Header *TableRow
+  Rows []*TableRow
+}
+
+type TableRow struct {
+  node
+  Cells []*TableCell
+}
+
+type TableCell struct {
+  node
+  Content []Node
+}
+
+type Text struct {
+  node
+  Text string
+}
+
+// Code is an inline code span.
+type Code struct {
+  node
+  Text string
+}
+
+// Link is any kind of link. Wiki is set for [[target]] links which start out
+// as LNK_WIKI until ResolveWikiLinks points them at a note. Embed is set for
+// ![[target]] embeds, Section holds the heading after a # in the target.
+type Link struct {
+  node
+  Type LinkType
+  Target string
+  Title string
+  Section string
+  Index int
+  Wiki bool
+  Embed bool
+}
+
+type LineBreak struct {
+  node
+}
+
+func (n *Document) Kind() NodeKind { return NODE_DOCUMENT }
+func (n *Heading) Kind() NodeKind { return NODE_HEADING }
+func (n *Paragraph) Kind() NodeKind { return NODE_PARAGRAPH }
+func (n *List) Kind() NodeKind { return NODE_LIST }
+func (n *ListItem) Kind() NodeKind { return NODE_LISTITEM }
+func (n *CodeBlock) Kind() NodeKind { return NODE_CODEBLOCK }
+func (n *Text) Kind() NodeKind { return NODE_TEXT }
+func (n *Code) Kind() NodeKind { return NODE_CODE }
+func (n *Link) Kind() NodeKind { return NODE_LINK }
+func (n *LineBreak) Kind() NodeKind { return NODE_LINEBREAK }
+func (n *Table) Kind() NodeKind { return NODE_TABLE }
+func (n *TableRow) Kind() NodeKind { return NODE_TABLEROW }
+func (n *TableCell) Kind() NodeKind { return NODE_TABLECELL }
+
+func (n *Document) Children() []Node { return n.Content }
+func (n *Heading) Children() []Node { return n.Content }
+func (n *Paragraph) Children() []Node { return n.Content }
+func (n *ListItem) Children() []Node { return n.Content }
+func (n *TableCell) Children() []Node { return n.Content }
+
+func (n *List) Children() []Node {
+  result := make([]Node, 0, len(n.Items))
+
+  for _, item := range n.Items {
+    result = append(result, item)
+  }
+
+  return result
+}
+
+func (n *Table) Children() []Node {
+  result := make([]Node, 0, len(n.Rows) + 1)
+
+  if n.Header != nil {
+    result = append(result, n.Header)
+  }
+
+  for _, row := range n.Rows {
+    result = append(result, row)
+  }
+
+  return result
+}
+
+func (n *TableRow) Children() []Node {
+  result := make([]Node, 0, len(n.Cells))
+
+  for _, cell := range n.Cells {
+    result = append(result, cell)
+  }
+
+  return result
+}
+
+// Walk visits n and then its children depth first. Returning false from fn
+// skips the children of that node.
+func Walk(n Node, fn func(n Node) bool) {
+  if !fn(n) {
+    return
+  }
+
+  for _, child := range n.Children() {
+    Walk(child, fn)
+  }
+}
+
+// Headings returns every heading in the document in source order.
+func (d *Document) Headings() []*Heading {
+  result := make([]*Heading, 0)
+
+  Walk(d, func(n Node) bool {
+    if h, ok := n.(*Heading); ok {
+      result = append(result, h)
+      return false
+    }
+    return true
+  })
+
+  return result
+}
+
+// Section returns the blocks under the heading with the given title, up to the
+// next heading of the same or higher level. The title is matched ignoring case.
+func (d *Document) Section(title string) ([]Node, bool) {
+  for i, block := range d.Content {
+    h, ok := block.(*Heading)
+
+    if !ok || !strings.EqualFold(strings.TrimSpace(PlainText(h)), title) {
+      continue
+    }
+
+    end := len(d.Content)
+
+    for j := i + 1; j < len(d.Content); j++ {
+      if next, ok := d.Content[j].(*Heading); ok && next.Level <= h.Level {
+        end = j
+        break
+      }
+    }
+
+    return d.Content[i:end], true
+  }
+
+  return nil, false
+}
+
+// Links returns every link in the document in source order.
+func (d *Document) Links() []*Link {
+  result := make([]*Link, 0)
+
+  Walk(d, func(n Node) bool {
+    if l, ok := n.(*Link); ok {
+      result = append(result, l)
+    }
+    return true
+  })
+
+  return result
+}
+
+// Tasks returns every task list item in the document in source order.
+func (d *Document) Tasks() []*ListItem {
+  result := make([]*ListItem, 0)
+
+  Walk(d, func(n Node) bool {
+    if item, ok := n.(*ListItem); ok && item.Task {
+      result = append(result, item)
+    }
+    return true
+  })
+
+  return result
+}
+
+// Resolver looks up the id of the note a wikilink target refers to.
+type Resolver func(target string) (string, bool)
+
+// ResolveWikiLinks turns wikilinks into note links using resolve. Targets that
+// don't resolve are marked LNK_UNRESOLVED.
+func ResolveWikiLinks(d *Document, resolve Resolver) {
+  for _, l := range d.Links() {
+    if !l.Wiki {
+      continue
+    }
+
+    if id, ok := resolve(l.Target); ok {
+      l.Type = LNK_ZK
+      l.Target = id
+    } else {
+      l.Type = LNK_UNRESOLVED
+    }
+  }
+}
+
+// PlainText returns the text of the node and its children without any markup.
+func PlainText(n Node) string {
+  result := ""
+
+  Walk(n, func(n Node) bool {
+    switch v := n.(type) {
+    case *Text:
+      result += v.Text
+    case *Code:
+      result += v.Text
+    case *Link:
+      result += v.Title
+    case *LineBreak:
+      result += " "
+    case *List:
+      return false
+    }
+    return true
+  })
+
+  return strings.TrimSpace(result)
+}
@@ -0,0 +1,307 @@
+package markdown
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAst(t *testing.T) {
+  t.Run("Builds blocks from markdown", func(t *testing.T) {
+    markdown := "# Title\n" +
+    "Some text\n" +
+    "more text\n" +
+    "\n" +
+    " - one\n" +
+    "   - two\n" +
+    " - three\n" +
+    "```go\n" +
+    "code\n" +
+    "```"
+
+    doc := Parse(markdown)
+
+    kinds := make([]NodeKind, 0)
+    for _, n := range doc.Content {
+      kinds = append(kinds, n.Kind())
+    }
+
+    expected := []NodeKind{NODE_HEADING, NODE_PARAGRAPH, NODE_LIST, NODE_CODEBLOCK}
+    if !reflect.DeepEqual(expected, kinds) {
+      t.Fatalf("Expected %+v, got %+v", expected, kinds)
+    }
+
+    para := doc.Content[1].(*Paragraph)
+    paraKinds := make([]NodeKind, 0)
+    for _, n := range para.Content {
+      paraKinds = append(paraKinds, n.Kind())
+    }
+
+    expected = []NodeKind{NODE_TEXT, NODE_LINEBREAK, NODE_TEXT}
+    if !reflect.DeepEqual(expected, paraKinds) {
+      t.Errorf("Expected paragraph %+v, got %+v", expected, paraKinds)
+    }
+
+    list := doc.Content[2].(*List)
+    if len(list.Items) != 2 {
+      t.Fatalf("Expected 2 top level items, got %d", len(list.Items))
+    }
+
+    sub, ok := list.Items[0].Content[1].(*List)
+    if !ok || sub.Level != 2 || PlainText(sub.Items[0]) != "two" {
+      t.Errorf("Expected nested list with 'two', got %+v", list.Items[0].Content)
+    }
+
+    if PlainText(list.Items[0]) != "one" {
+      t.Errorf("Expected item text 'one', got '%s'", PlainText(list.Items[0]))
+    }
+
+    code := doc.Content[3].(*CodeBlock)
+    if code.Language != "go" || code.Text != "code" {
+      t.Errorf("Expected go code block, got %+v", code)
+    }
+  })
+
+  t.Run("Nodes have source positions", func(t *testing.T) {
+    markdown := "# Title\n\nHey [Link](zk:123) there"
+    doc := Parse(markdown)
+
+    heading := doc.Content[0]
+    if heading.Pos() != (Position{Offset: 0, Line: 1, Column: 1}) {
+      t.Errorf("Unexpected heading start %+v", heading.Pos())
+    }
+
+    if heading.End() != (Position{Offset: 7, Line: 1, Column: 8}) {
+      t.Errorf("Unexpected heading end %+v", heading.End())
+    }
+
+    links := doc.Links()
+    if len(links) != 1 {
+      t.Fatalf("Expected 1 link, got %d", len(links))
+    }
+
+    if links[0].Pos() != (Position{Offset: 13, Line: 3, Column: 5}) {
+      t.Errorf("Unexpected link start %+v", links[0].Pos())
+    }
+
+    if links[0].End() != (Position{Offset: 27, Line: 3, Column: 19}) {
+      t.Errorf("Unexpected link end %+v", links[0].End())
+    }
+
+    if links[0].Type != LNK_ZK || links[0].Target != "123" || links[0].Title != "Link" {
+      t.Errorf("Unexpected link %+v", links[0])
+    }
+  })
+
+  t.Run("Can list headings", func(t *testing.T) {
+    doc := Parse("# One\ntext\n## Two\n - item")
+
+    headings := doc.Headings()
+    if len(headings) != 2 {
+      t.Fatalf("Expected 2 headings, got %d", len(headings))
+    }
+
+    if PlainText(headings[0]) != "One" || headings[0].Level != 1 {
+      t.Errorf("Unexpected heading %+v", headings[0])
+    }
+
+    if PlainText(headings[1]) != "Two" || headings[1].Level != 2 {
+      t.Errorf("Unexpected heading %+v", headings[1])
+    }
+  })
+
+  t.Run("Walk can skip children", func(t *testing.T) {
+    doc := Parse("# [Skipped](zk:1)\n[Found](zk:2)")
+    found := make([]string, 0)
+
+    Walk(doc, func(n Node) bool {
+      if n.Kind() == NODE_HEADING {
+        return false
+      }
+
+      if l, ok := n.(*Link); ok {
+        found = append(found, l.Title)
+      }
+      return true
+    })
+
+    if !reflect.DeepEqual([]string{"Found"}, found) {
+      t.Errorf("Expected only Found, got %+v", found)
+    }
+  })
+
+  t.Run("Unterminated code block does not hang", func(t *testing.T) {
+    doc := Parse("```\ncode")
+
+    if len(doc.Content) != 1 || doc.Content[0].Kind() != NODE_CODEBLOCK {
+      t.Errorf("Expected a single code block, got %+v", doc.Content)
+    }
+  })
+}
+
+func TestTasks(t *testing.T) {
+  t.Run("Can parse task items", func(t *testing.T) {
+    markdown := " - [ ] open\n - [x] [Done](zk:1)\n 1. [X] ordered\n - [link](zk:2)\n - [ ]"
+    tasks := Parse(markdown).Tasks()
+
+    if len(tasks) != 4 {
+      t.Fatalf("Expected 4 tasks, got %d", len(tasks))
+    }
+
+    expected := []struct{
+      checked bool
+      text string
+      offset int
+    }{
+      {checked: false, text: "open", offset: 3},
+      {checked: true, text: "Done", offset: 15},
+      {checked: true, text: "ordered", offset: 36},
+      {checked: false, text: "", offset: 67},
+    }
+
+    for i, e := range expected {
+      if tasks[i].Checked != e.checked || PlainText(tasks[i]) != e.text || tasks[i].TaskPos.Offset != e.offset || tasks[i].TaskIndex != i {
+        t.Errorf("Expected %+v, got %+v '%s' index %d", e, tasks[i], PlainText(tasks[i]), i)
+      }
+    }
+
+    if len(Parse(markdown).Links()) != 2 {
+      t.Errorf("Expected links to still parse after a checkbox")
+    }
+  })
+
+  t.Run("Can toggle tasks", func(t *testing.T) {
+    markdown := " - [ ] one\n - [x] two"
+
+    got, err := ToggleTask(markdown, 0)
+    if err != nil || got != " - [x] one\n - [x] two" {
+      t.Errorf("Expected first task checked, got '%s' %v", got, err)
+    }
+
+    got, err = ToggleTask(got, 1)
+    if err != nil || got != " - [x] one\n - [ ] two" {
+      t.Errorf("Expected second task unchecked, got '%s' %v", got, err)
+    }
+
+    if _, err := ToggleTask(markdown, 5); err == nil {
+      t.Errorf("Expected error for missing task")
+    }
+  })
+
+  t.Run("Renders task checkboxes", func(t *testing.T) {
+    got, _ := MarkdownToTui(" - [ ] one\n - [x] two")
+    expected := " [green]ﱣ[-] [\"task-0\"][yellow][-][\"\"] one\n [green]ﱣ[-] [\"task-1\"][green][-][\"\"] two"
+
+    if got != expected {
+      t.Errorf("Expected '%s', got '%s'", expected, got)
+    }
+  })
+}
+
+func TestWikiLinks(t *testing.T) {
+  t.Run("Can parse wikilinks", func(t *testing.T) {
+    links := Parse("See [[1625612345]] and [[Some Note|that note]] or [Web](http://x)").Links()
+
+    if len(links) != 3 {
+      t.Fatalf("Expected 3 links, got %d", len(links))
+    }
+
+    if links[0].Type != LNK_WIKI || links[0].Target != "1625612345" || links[0].Title != "1625612345" || !links[0].Wiki {
+      t.Errorf("Unexpected link %+v", links[0])
+    }
+
+    if links[1].Target != "Some Note" || links[1].Title != "that note" || links[1].Index != 1 {
+      t.Errorf("Unexpected link %+v", links[1])
+    }
+
+    if links[2].Type != LNK_URL || links[2].Index != 2 {
+      t.Errorf("Unexpected link %+v", links[2])
+    }
+  })
+
+  t.Run("Resolves wikilinks and flags the rest", func(t *testing.T) {
+    doc := Parse(" - [[Known]]\n - [[Missing]]")
+
+    ResolveWikiLinks(doc, func(target string) (string, bool) {
+      if target == "Known" {
+        return "123", true
+      }
+      return "", false
+    })
+
+    links := doc.Links()
+
+    if links[0].Type != LNK_ZK || links[0].Target != "123" {
+      t.Errorf("Expected resolved note link, got %+v", links[0])
+    }
+
+    if links[1].Type != LNK_UNRESOLVED || links[1].Target != "Missing" {
+      t.Errorf("Expected unresolved link, got %+v", links[1])
+    }
+
+    got := NewTuiRenderer().Render(doc)
+    if !strings.Contains(got, `["1"]`) || !strings.Contains(got, "[red::u]Missing") {
+      t.Errorf("Expected unresolved link to be flagged, got '%s'", got)
+    }
+  })
+}
+
+func TestExtractLinks(t *testing.T) {
+  t.Run("Link indexes match rendered regions", func(t *testing.T) {
+    markdown := "Some `[code](zk:1)` then ![pic](zka:a.png) and [[Note]] and [Next](zk:2)"
+    links := ExtractLinks(markdown, func(target string) (string, bool) {
+      return "99", true
+    })
+
+    if len(links) != 3 {
+      t.Fatalf("Expected 3 links, got %d", len(links))
+    }
+
+    if links[0].Type != LNK_IMAGE || links[0].Target != "zka:a.png" {
+      t.Errorf("Expected image with raw target, got %+v", links[0])
+    }
+
+    if links[1].Type != LNK_ZK || links[1].Target != "99" {
+      t.Errorf("Expected resolved wikilink, got %+v", links[1])
+    }
+
+    got, _ := MarkdownToTui(markdown)
+
+    for _, l := range links {
+      if !strings.Contains(got, `["` + strconv.Itoa(l.Index) + `"]`) {
+        t.Errorf("Expected region for link %d in '%s'", l.Index, got)
+      }
+    }
+
+    if links[2].Index != 2 || links[2].Target != "2" || links[2].Pos().Offset != 60 {
+      t.Errorf("Unexpected link %+v", links[2])
+    }
+  })
+
+  t.Run("Can replace links in place", func(t *testing.T) {
+    markdown := "# H [a](x.md)\n- ![[Pic]] and `[[code]]`\n\n```\n[b](x.md)\n```\n| [[T]] | [c](y.md) |\n|---|---|\n"
+    sources := make([]string, 0)
+
+    got := ReplaceLinks(markdown, func(l *Link, source string) (string, bool) {
+      sources = append(sources, source)
+
+      if l.Target == "y.md" {
+        return "", false
+      }
+
+      return "<" + l.Target + ">", true
+    })
+
+    expected := "# H <x.md>\n- <Pic> and `[[code]]`\n\n```\n[b](x.md)\n```\n| <T> | [c](y.md) |\n|---|---|\n"
+
+    if got != expected {
+      t.Errorf("Expected '%s', got '%s'", expected, got)
+    }
+
+    if strings.Join(sources, " ") != "[a](x.md) ![[Pic]] [[T]] [c](y.md)" {
+      t.Errorf("Unexpected link sources %v", sources)
+    }
+  })
+}
+
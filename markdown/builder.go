@@ -0,0 +1,304 @@
+package markdown
+
+import (
+  "sort"
+  "strconv"
+)
+
+type builder struct {
+  tok tokenizer
+  text string
+  lineStarts []int
+  doc *Document
+  inline *[]Node
+  para *Paragraph
+  lists []*List
+  newlines int
+  lastNewline int
+  tasks int
+}
+
+// Parse converts markdown text into a Document.
+func Parse(markdown string) *Document {
+  tok := newParser(markdown)
+  return buildDocument(&tok, markdown)
+}
+
+func buildDocument(tok tokenizer, text string) *Document {
+  b := builder{tok: tok, text: text, lineStarts: []int{0}}
+
+  for i, c := range text {
+    if c == '\n' {
+      b.lineStarts = append(b.lineStarts, i + 1)
+    }
+  }
+
+  b.doc = &Document{}
+  b.doc.start = b.position(0)
+
+  return b.build()
+}
+
+func (b *builder) position(offset int) Position {
+  if offset > len(b.text) {
+    offset = len(b.text)
+  }
+
+  line := sort.Search(len(b.lineStarts), func(i int) bool {
+    return b.lineStarts[i] > offset
+  })
+
+  return Position{Offset: offset, Line: line, Column: offset - b.lineStarts[line - 1] + 1}
+}
+
+func (b *builder) build() *Document {
+  for {
+    tok := b.tok.NextToken()
+
+    //The tokenizer hands back empty text once it can't make progress so treat it as the end.
+    if tok.Type == TOK_EOF || (tok.Type == TOK_TEXT && tok.Format == TXT_PLAIN && tok.Text == "") {
+      b.doc.end = b.position(tok.Pos)
+      break
+    }
+
+    switch tok.Type {
+    case TOK_NEWLINE:
+      b.newlines++
+      b.lastNewline = tok.Pos
+      continue
+    case TOK_HEADING:
+      b.closeBlocks()
+      h := &Heading{Level: tok.Level}
+      h.start = b.position(tok.Pos)
+      h.end = b.position(tok.End)
+      b.doc.Content = append(b.doc.Content, h)
+      b.inline = &h.Content
+      b.addText(tok)
+    case TOK_BULLET, TOK_ORDEREDITEM:
+      b.addListItem(tok)
+    case TOK_CODEBLOCK:
+      b.closeBlocks()
+      cb := &CodeBlock{Language: tok.Language, Text: tok.Text}
+      cb.start = b.position(tok.Pos)
+      cb.end = b.position(tok.End)
+      b.doc.Content = append(b.doc.Content, cb)
+    case TOK_TABLE:
+      b.closeBlocks()
+      b.addTable(tok)
+    case TOK_TEXT, TOK_LINK:
+      if b.inline == nil || b.newlines > 1 || (b.newlines == 1 && b.para == nil) {
+        b.closeBlocks()
+        b.para = &Paragraph{}
+        b.para.start = b.position(tok.Pos)
+        b.doc.Content = append(b.doc.Content, b.para)
+        b.inline = &b.para.Content
+      } else if b.newlines == 1 {
+        br := &LineBreak{}
+        br.start = b.position(b.lastNewline)
+        br.end = b.position(b.lastNewline + 1)
+        b.para.Content = append(b.para.Content, br)
+      }
+
+      if tok.Type == TOK_LINK {
+        b.addLink(tok)
+      } else {
+        b.addText(tok)
+      }
+    }
+
+    b.newlines = 0
+  }
+
+  b.closeBlocks()
+  fixEnds(b.doc)
+
+  return b.doc
+}
+
+func (b *builder) closeBlocks() {
+  b.inline = nil
+  b.para = nil
+  b.lists = nil
+}
+
+// addText appends the text carried by tok to the current inline container. Block
+// tokens carry their text after the marker so the text starts at the end of the token.
+func (b *builder) addText(tok token) {
+  if tok.Text == "" {
+    return
+  }
+
+  start := b.position(tok.End - len(tok.Text))
+  end := b.position(tok.End)
+
+  if tok.Format == TXT_CODE {
+    c := &Code{Text: tok.Text}
+    c.start = b.position(tok.Pos)
+    c.end = end
+    *b.inline = append(*b.inline, c)
+    return
+  }
+
+  t := &Text{Text: tok.Text}
+  t.start = start
+  t.end = end
+  *b.inline = append(*b.inline, t)
+}
+
+func (b *builder) addLink(tok token) {
+  for _, l := range b.tok.Links() {
+    if strconv.Itoa(l.Index) != tok.Text {
+      continue
+    }
+
+    lnk := &Link{Type: l.Type, Target: l.Target, Title: l.Title, Section: l.Section, Index: l.Index}
+    lnk.Wiki = l.Type == LNK_WIKI || l.Type == LNK_EMBED
+    lnk.Embed = l.Type == LNK_EMBED
+    lnk.start = b.position(tok.Pos)
+    lnk.end = b.position(tok.End)
+    *b.inline = append(*b.inline, lnk)
+    return
+  }
+}
+
+func (b *builder) addTable(tok token) {
+  table := &Table{Align: tok.Align}
+  table.start = b.position(tok.Pos)
+  table.end = b.position(tok.End)
+
+  for i, cells := range tok.Rows {
+    row := &TableRow{}
+
+    for _, c := range cells {
+      cell := &TableCell{}
+      cell.start = b.position(c.Pos)
+      cell.end = b.position(c.End)
+      b.inline = &cell.Content
+
+      for _, t := range c.Tokens {
+        if t.Type == TOK_LINK {
+          b.addLink(t)
+        } else {
+          b.addText(t)
+        }
+      }
+
+      row.Cells = append(row.Cells, cell)
+    }
+
+    if len(row.Cells) > 0 {
+      row.start = row.Cells[0].start
+      row.end = row.Cells[len(row.Cells) - 1].end
+    }
+
+    if i == 0 {
+      table.Header = row
+    } else {
+      table.Rows = append(table.Rows, row)
+    }
+  }
+
+  b.inline = nil
+  b.doc.Content = append(b.doc.Content, table)
+}
+
+func (b *builder) addListItem(tok token) {
+  ordered := tok.Type == TOK_ORDEREDITEM
+
+  if b.newlines > 1 || b.para != nil {
+    b.closeBlocks()
+  }
+
+  for len(b.lists) > 0 && b.lists[len(b.lists) - 1].Level > tok.Level {
+    b.lists = b.lists[:len(b.lists) - 1]
+  }
+
+  if len(b.lists) > 0 {
+    top := b.lists[len(b.lists) - 1]
+
+    if top.Level < tok.Level {
+      b.lists = append(b.lists, b.newList(tok, ordered))
+    } else if top.Ordered != ordered {
+      b.lists = b.lists[:len(b.lists) - 1]
+
+      if len(b.lists) > 0 {
+        b.lists = append(b.lists, b.newList(tok, ordered))
+      }
+    }
+  }
+
+  if len(b.lists) == 0 {
+    b.closeBlocks()
+    b.lists = append(b.lists, b.newList(tok, ordered))
+  }
+
+  list := b.lists[len(b.lists) - 1]
+  item := &ListItem{Level: tok.Level}
+  item.start = b.position(tok.Pos)
+  item.end = b.position(tok.End)
+  list.Items = append(list.Items, item)
+
+  if tok.Task {
+    item.Task = true
+    item.Checked = tok.Checked
+    item.TaskPos = b.position(tok.TaskPos)
+    item.TaskIndex = b.tasks
+    b.tasks++
+  }
+
+  b.inline = &item.Content
+  b.addText(tok)
+}
+
+// newList creates a list for tok and attaches it to the last item of the
+// enclosing list, or to the document when there is no enclosing list.
+func (b *builder) newList(tok token, ordered bool) *List {
+  list := &List{Level: tok.Level, Ordered: ordered}
+  list.start = b.position(tok.Pos)
+  list.end = b.position(tok.End)
+
+  if len(b.lists) == 0 {
+    b.doc.Content = append(b.doc.Content, list)
+    return list
+  }
+
+  parent := b.lists[len(b.lists) - 1]
+
+  if len(parent.Items) == 0 {
+    b.doc.Content = append(b.doc.Content, list)
+    return list
+  }
+
+  item := parent.Items[len(parent.Items) - 1]
+  item.Content = append(item.Content, list)
+
+  return list
+}
+
+// fixEnds stretches the end of every container to cover its children.
+func fixEnds(n Node) Position {
+  end := n.End()
+
+  for _, child := range n.Children() {
+    childEnd := fixEnds(child)
+
+    if childEnd.Offset > end.Offset {
+      end = childEnd
+    }
+  }
+
+  switch v := n.(type) {
+  case *Heading:
+    v.end = end
+  case *Paragraph:
+    v.end = end
+  case *List:
+    v.end = end
+  case *ListItem:
+    v.end = end
+  case *Table:
+    v.end = end
+  }
+
+  return end
+}
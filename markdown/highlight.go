@@ -0,0 +1,389 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/tview"
+)
+
+const (
+  HL_TEXT highlightClass = iota
+  HL_KEYWORD
+  HL_TYPE
+  HL_STRING
+  HL_NUMBER
+  HL_COMMENT
+  HL_KEY
+)
+
+type highlightClass int
+
+// Theme holds the tview colors used to paint code blocks.
+type Theme struct {
+  Text string
+  Background string
+  Keyword string
+  Type string
+  String string
+  Number string
+  Comment string
+  Key string
+  LineNumber string
+}
+
+var Themes = map[string]Theme{
+  "default": {
+    Text: "green",
+    Background: "gray",
+    Keyword: "yellow",
+    Type: "aqua",
+    String: "white",
+    Number: "fuchsia",
+    Comment: "silver",
+    Key: "aqua",
+    LineNumber: "black",
+  },
+  "dark": {
+    Text: "white",
+    Background: "black",
+    Keyword: "fuchsia",
+    Type: "aqua",
+    String: "yellow",
+    Number: "orange",
+    Comment: "gray",
+    Key: "lime",
+    LineNumber: "gray",
+  },
+  "light": {
+    Text: "black",
+    Background: "white",
+    Keyword: "blue",
+    Type: "teal",
+    String: "maroon",
+    Number: "purple",
+    Comment: "gray",
+    Key: "navy",
+    LineNumber: "silver",
+  },
+}
+
+type codeLanguage struct {
+  keywords []string
+  types []string
+  lineComments []string
+  blockComment []string
+  quotes []string
+  ignoreCase bool
+  keys bool
+}
+
+var goLanguage = codeLanguage{
+  keywords: []string{"break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough",
+    "for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select",
+    "struct", "switch", "type", "var", "true", "false", "nil", "iota"},
+  types: []string{"bool", "byte", "complex64", "complex128", "error", "float32", "float64", "int", "int8",
+    "int16", "int32", "int64", "rune", "string", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+    "any", "append", "cap", "close", "copy", "delete", "len", "make", "new", "panic", "print", "println", "recover"},
+  lineComments: []string{"//"},
+  blockComment: []string{"/*", "*/"},
+  quotes: []string{`"`, "'", "`"},
+}
+
+var shellLanguage = codeLanguage{
+  keywords: []string{"if", "then", "else", "elif", "fi", "case", "esac", "for", "while", "until", "do", "done",
+    "in", "function", "return", "select", "time", "local", "export", "readonly", "declare", "unset", "source"},
+  types: []string{"echo", "cd", "exit", "set", "shift", "test", "read", "printf", "eval", "exec", "trap"},
+  lineComments: []string{"#"},
+  quotes: []string{`"`, "'", "`"},
+}
+
+var yamlLanguage = codeLanguage{
+  keywords: []string{"true", "false", "null", "yes", "no", "on", "off"},
+  lineComments: []string{"#"},
+  quotes: []string{`"`, "'"},
+  keys: true,
+}
+
+var jsonLanguage = codeLanguage{
+  keywords: []string{"true", "false", "null"},
+  quotes: []string{`"`},
+  keys: true,
+}
+
+var pythonLanguage = codeLanguage{
+  keywords: []string{"and", "as", "assert", "async", "await", "break", "class", "continue", "def", "del",
+    "elif", "else", "except", "finally", "for", "from", "global", "if", "import", "in", "is", "lambda",
+    "nonlocal", "not", "or", "pass", "raise", "return", "try", "while", "with", "yield", "True", "False", "None"},
+  types: []string{"int", "float", "str", "bool", "list", "dict", "set", "tuple", "bytes", "object", "len",
+    "print", "range", "open", "self", "super", "isinstance", "enumerate", "zip"},
+  lineComments: []string{"#"},
+  quotes: []string{`"""`, "'''", `"`, "'"},
+}
+
+var sqlLanguage = codeLanguage{
+  keywords: []string{"select", "from", "where", "and", "or", "not", "insert", "into", "values", "update",
+    "set", "delete", "create", "table", "drop", "alter", "index", "join", "left", "right", "inner", "outer",
+    "on", "as", "group", "by", "order", "having", "limit", "offset", "distinct", "union", "all", "null",
+    "is", "in", "like", "between", "primary", "key", "foreign", "references", "default", "case", "when",
+    "then", "else", "end", "asc", "desc", "exists", "view", "with"},
+  types: []string{"int", "integer", "bigint", "smallint", "text", "varchar", "char", "boolean", "date",
+    "timestamp", "float", "real", "decimal", "numeric", "blob", "count", "sum", "avg", "min", "max"},
+  lineComments: []string{"--"},
+  blockComment: []string{"/*", "*/"},
+  quotes: []string{"'", `"`},
+  ignoreCase: true,
+}
+
+var codeLanguages = map[string]*codeLanguage{
+  "go": &goLanguage,
+  "golang": &goLanguage,
+  "sh": &shellLanguage,
+  "bash": &shellLanguage,
+  "shell": &shellLanguage,
+  "zsh": &shellLanguage,
+  "yaml": &yamlLanguage,
+  "yml": &yamlLanguage,
+  "json": &jsonLanguage,
+  "python": &pythonLanguage,
+  "py": &pythonLanguage,
+  "sql": &sqlLanguage,
+}
+
+type codeSegment struct {
+  class highlightClass
+  text string
+}
+
+func lookupLanguage(language string) *codeLanguage {
+  fields := strings.Fields(strings.ToLower(language))
+
+  if len(fields) == 0 {
+    return nil
+  }
+
+  return codeLanguages[fields[0]]
+}
+
+func (l *codeLanguage) contains(words []string, word string) bool {
+  for _, w := range words {
+    if w == word || (l.ignoreCase && strings.EqualFold(w, word)) {
+      return true
+    }
+  }
+
+  return false
+}
+
+// highlight splits code into segments classified by the rules of the language.
+func (l *codeLanguage) highlight(code string) []codeSegment {
+  result := make([]codeSegment, 0)
+  plain := ""
+  lineStart := true
+
+  emit := func(class highlightClass, text string) {
+    if plain != "" {
+      result = append(result, codeSegment{class: HL_TEXT, text: plain})
+      plain = ""
+    }
+    result = append(result, codeSegment{class: class, text: text})
+  }
+
+  i := 0
+  for i < len(code) {
+    rest := code[i:]
+
+    if rest[0] == '\n' {
+      plain += "\n"
+      lineStart = true
+      i++
+      continue
+    }
+
+    if handled := func() bool {
+      for _, c := range l.lineComments {
+        if strings.HasPrefix(rest, c) {
+          end := strings.Index(rest, "\n")
+          if end == -1 {
+            end = len(rest)
+          }
+          emit(HL_COMMENT, rest[:end])
+          i += end
+          return true
+        }
+      }
+
+      if len(l.blockComment) == 2 && strings.HasPrefix(rest, l.blockComment[0]) {
+        end := strings.Index(rest[len(l.blockComment[0]):], l.blockComment[1])
+        if end == -1 {
+          end = len(rest)
+        } else {
+          end += len(l.blockComment[0]) + len(l.blockComment[1])
+        }
+        emit(HL_COMMENT, rest[:end])
+        i += end
+        return true
+      }
+
+      for _, q := range l.quotes {
+        if strings.HasPrefix(rest, q) {
+          end := len(q)
+          for end < len(rest) && !strings.HasPrefix(rest[end:], q) {
+            if rest[end] == '\\' && q != "`" {
+              end++
+            }
+            end++
+          }
+          end += len(q)
+          if end > len(rest) {
+            end = len(rest)
+          }
+
+          class := HL_STRING
+          if l.keys && strings.HasPrefix(strings.TrimLeft(rest[end:], " "), ":") {
+            class = HL_KEY
+          }
+          emit(class, rest[:end])
+          i += end
+          return true
+        }
+      }
+
+      return false
+    }(); handled {
+      lineStart = false
+      continue
+    }
+
+    r, size := utf8.DecodeRuneInString(rest)
+    last, _ := utf8.DecodeLastRuneInString(plain)
+
+    if unicode.IsDigit(r) && (plain == "" || !isWordChar(last)) {
+      end := wordEnd(rest, func(c rune) bool { return isWordChar(c) || c == '.' })
+      emit(HL_NUMBER, rest[:end])
+      i += end
+      lineStart = false
+      continue
+    }
+
+    if isWordChar(r) {
+      end := wordEnd(rest, func(c rune) bool { return isWordChar(c) || (l.keys && c == '-') })
+      word := rest[:end]
+
+      if l.keys && lineStart && strings.HasPrefix(rest[end:], ":") {
+        emit(HL_KEY, word)
+      } else if l.contains(l.keywords, word) {
+        emit(HL_KEYWORD, word)
+      } else if l.contains(l.types, word) {
+        emit(HL_TYPE, word)
+      } else {
+        plain += word
+      }
+
+      i += end
+      lineStart = false
+      continue
+    }
+
+    if r != ' ' && r != '\t' && r != '-' {
+      lineStart = false
+    }
+
+    plain += rest[:size]
+    i += size
+  }
+
+  if plain != "" {
+    result = append(result, codeSegment{class: HL_TEXT, text: plain})
+  }
+
+  return result
+}
+
+// wordEnd returns the byte length of the runes at the start of text that
+// match in.
+func wordEnd(text string, in func(r rune) bool) int {
+  end := 0
+  for end < len(text) {
+    r, size := utf8.DecodeRuneInString(text[end:])
+    if !in(r) {
+      break
+    }
+    end += size
+  }
+  return end
+}
+
+func isWordChar(r rune) bool {
+  return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (t Theme) color(class highlightClass) string {
+  switch class {
+  case HL_KEYWORD:
+    return t.Keyword
+  case HL_TYPE:
+    return t.Type
+  case HL_STRING:
+    return t.String
+  case HL_NUMBER:
+    return t.Number
+  case HL_COMMENT:
+    return t.Comment
+  case HL_KEY:
+    return t.Key
+  }
+
+  return t.Text
+}
+
+// renderCode paints code with the theme colors, highlighting it when the
+// language is known and numbering each line when asked to.
+func (r *TuiRenderer) renderCode(code string, language string) string {
+  segments := []codeSegment{{class: HL_TEXT, text: code}}
+
+  if lang := lookupLanguage(language); lang != nil {
+    segments = lang.highlight(code)
+  }
+
+  lineCount := strings.Count(code, "\n") + 1
+  width := len(fmt.Sprintf("%d", lineCount))
+  line := 1
+
+  lineNumber := func() string {
+    if !r.LineNumbers {
+      return ""
+    }
+
+    result := fmt.Sprintf("[%s]%*d [%s]", r.Theme.LineNumber, width, line, r.Theme.Text)
+    line++
+    return result
+  }
+
+  result := lineNumber()
+
+  for _, seg := range segments {
+    for i, part := range strings.Split(seg.text, "\n") {
+      if i > 0 {
+        result += "\n" + lineNumber()
+      }
+
+      if part == "" {
+        continue
+      }
+
+      // Code like a[i] would otherwise be read as a color tag.
+      part = tview.Escape(part)
+
+      if seg.class == HL_TEXT {
+        result += part
+      } else {
+        result += fmt.Sprintf("[%s]%s[%s]", r.Theme.color(seg.class), part, r.Theme.Text)
+      }
+    }
+  }
+
+  return result
+}
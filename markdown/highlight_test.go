@@ -0,0 +1,147 @@
+package markdown
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+  t.Run("Classifies code by language", func(t *testing.T) {
+    cases := []struct{
+      language string
+      code string
+      expected []codeSegment
+    }{
+      {
+        language: "go",
+        code: "func main() { // hi",
+        expected: []codeSegment{
+          {class: HL_KEYWORD, text: "func"},
+          {class: HL_TEXT, text: " main() { "},
+          {class: HL_COMMENT, text: "// hi"},
+        },
+      },
+      {
+        language: "go",
+        code: "naïve := 1→2 // ça",
+        expected: []codeSegment{
+          {class: HL_TEXT, text: "naïve := "},
+          {class: HL_NUMBER, text: "1"},
+          {class: HL_TEXT, text: "→"},
+          {class: HL_NUMBER, text: "2"},
+          {class: HL_TEXT, text: " "},
+          {class: HL_COMMENT, text: "// ça"},
+        },
+      },
+      {
+        language: "sh",
+        code: "echo \"hi\" # done",
+        expected: []codeSegment{
+          {class: HL_TYPE, text: "echo"},
+          {class: HL_TEXT, text: " "},
+          {class: HL_STRING, text: `"hi"`},
+          {class: HL_TEXT, text: " "},
+          {class: HL_COMMENT, text: "# done"},
+        },
+      },
+      {
+        language: "yaml",
+        code: "- name: true",
+        expected: []codeSegment{
+          {class: HL_TEXT, text: "- "},
+          {class: HL_KEY, text: "name"},
+          {class: HL_TEXT, text: ": "},
+          {class: HL_KEYWORD, text: "true"},
+        },
+      },
+      {
+        language: "json",
+        code: `{"a": 12}`,
+        expected: []codeSegment{
+          {class: HL_TEXT, text: "{"},
+          {class: HL_KEY, text: `"a"`},
+          {class: HL_TEXT, text: ": "},
+          {class: HL_NUMBER, text: "12"},
+          {class: HL_TEXT, text: "}"},
+        },
+      },
+      {
+        language: "python",
+        code: `def f(): return """x"""`,
+        expected: []codeSegment{
+          {class: HL_KEYWORD, text: "def"},
+          {class: HL_TEXT, text: " f(): "},
+          {class: HL_KEYWORD, text: "return"},
+          {class: HL_TEXT, text: " "},
+          {class: HL_STRING, text: `"""x"""`},
+        },
+      },
+      {
+        language: "sql",
+        code: "SELECT count(*) FROM t",
+        expected: []codeSegment{
+          {class: HL_KEYWORD, text: "SELECT"},
+          {class: HL_TEXT, text: " "},
+          {class: HL_TYPE, text: "count"},
+          {class: HL_TEXT, text: "(*) "},
+          {class: HL_KEYWORD, text: "FROM"},
+          {class: HL_TEXT, text: " t"},
+        },
+      },
+    }
+
+    for _, c := range cases {
+      got := lookupLanguage(c.language).highlight(c.code)
+
+      if !reflect.DeepEqual(c.expected, got) {
+        t.Errorf("Expected %+v, got %+v for %s", c.expected, got, c.language)
+      }
+    }
+  })
+
+  t.Run("Unknown languages are not highlighted", func(t *testing.T) {
+    r := NewTuiRenderer()
+    got := r.renderBlock(&CodeBlock{Language: "cobol", Text: "func"})
+    expected := "[green:gray]func\n[-:-:-]"
+
+    if got != expected {
+      t.Errorf("Expected '%s', got '%s'", expected, got)
+    }
+  })
+
+  t.Run("Renders code with theme colors", func(t *testing.T) {
+    r := NewTuiRenderer()
+    r.Theme = Themes["light"]
+
+    got := r.renderBlock(&CodeBlock{Language: "go", Text: "return 1"})
+    expected := "[black:white][blue]return[black] [purple]1[black]\n[-:-:-]"
+
+    if got != expected {
+      t.Errorf("Expected '%s', got '%s'", expected, got)
+    }
+  })
+
+  t.Run("Can number lines", func(t *testing.T) {
+    r := NewTuiRenderer()
+    r.LineNumbers = true
+
+    got := r.renderBlock(&CodeBlock{Language: "go", Text: "a\n/* b\nc */"})
+    expected := "[green:gray][black]1 [green]a\n[black]2 [green][silver]/* b[green]\n[black]3 [green][silver]c */[green]\n[-:-:-]"
+
+    if got != expected {
+      t.Errorf("Expected '%s', got '%s'", expected, got)
+    }
+  })
+
+  t.Run("Escapes code that looks like tags", func(t *testing.T) {
+    r := NewTuiRenderer()
+    r.Theme = Themes["light"]
+
+    got := r.renderBlock(&CodeBlock{Language: "go", Text: "x[red] = \"[i]\""})
+    expected := "[black:white]x[red[] = [maroon]\"[i[]\"[black]\n[-:-:-]"
+
+    if got != expected {
+      t.Errorf("Expected '%s', got '%s'", expected, got)
+    }
+  })
+}
@@ -0,0 +1,206 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// HtmlRenderer renders a Document into HTML. Everything from the note is
+// escaped, the renderer never passes raw HTML through.
+type HtmlRenderer struct {
+  // LinkURL returns the href of a link, or "" to render the link as plain
+  // text. SafeURL is used when it is nil.
+  LinkURL func(l *Link) string
+}
+
+func NewHtmlRenderer() *HtmlRenderer {
+  return &HtmlRenderer{}
+}
+
+// SafeURL returns the target of url and image links that use http, https or
+// mailto, or are relative. Other links have no url outside of kn.
+func SafeURL(l *Link) string {
+  if l.Type != LNK_URL && l.Type != LNK_IMAGE {
+    return ""
+  }
+
+  target := strings.TrimSpace(l.Target)
+  lower := strings.ToLower(target)
+
+  if scheme := strings.Index(lower, ":"); scheme != -1 && !strings.ContainsAny(lower[:scheme], "/?#") {
+    switch lower[:scheme] {
+    case "http", "https", "mailto":
+    default:
+      return ""
+    }
+  }
+
+  return target
+}
+
+func (r *HtmlRenderer) Render(doc *Document) string {
+  result := ""
+  heading := 0
+
+  for _, block := range doc.Content {
+    if h, ok := block.(*Heading); ok {
+      result += fmt.Sprintf("<h%d id=\"heading-%d\">%s</h%d>\n", h.Level, heading, r.renderInline(h.Content), h.Level)
+      heading++
+      continue
+    }
+
+    result += r.renderBlock(block)
+  }
+
+  return result
+}
+
+func (r *HtmlRenderer) renderBlock(n Node) string {
+  switch v := n.(type) {
+  case *Paragraph:
+    return "<p>" + r.renderInline(v.Content) + "</p>\n"
+  case *List:
+    return r.renderList(v)
+  case *Table:
+    return r.renderTable(v)
+  case *CodeBlock:
+    class := ""
+
+    if v.Language != "" {
+      class = fmt.Sprintf(" class=\"language-%s\"", html.EscapeString(v.Language))
+    }
+
+    return fmt.Sprintf("<pre><code%s>%s</code></pre>\n", class, html.EscapeString(v.Text))
+  }
+
+  return ""
+}
+
+// renderList renders nested lists inside the item they follow.
+func (r *HtmlRenderer) renderList(list *List) string {
+  tag := "ul"
+
+  if list.Ordered {
+    tag = "ol"
+  }
+
+  result := "<" + tag + ">\n"
+
+  for _, item := range list.Items {
+    result += "<li>"
+
+    if item.Task {
+      if item.Checked {
+        result += `<input type="checkbox" checked disabled> `
+      } else {
+        result += `<input type="checkbox" disabled> `
+      }
+    }
+
+    inline := make([]Node, 0)
+    sublists := ""
+
+    for _, child := range item.Content {
+      if sub, ok := child.(*List); ok {
+        sublists += r.renderList(sub)
+      } else {
+        inline = append(inline, child)
+      }
+    }
+
+    result += r.renderInline(inline)
+
+    if sublists != "" {
+      result += "\n" + sublists
+    }
+
+    result += "</li>\n"
+  }
+
+  return result + "</" + tag + ">\n"
+}
+
+func (r *HtmlRenderer) renderTable(table *Table) string {
+  result := "<table>\n"
+
+  if table.Header != nil {
+    result += "<thead>\n" + r.renderRow(table.Header, table.Align, "th") + "</thead>\n"
+  }
+
+  result += "<tbody>\n"
+
+  for _, row := range table.Rows {
+    result += r.renderRow(row, table.Align, "td")
+  }
+
+  return result + "</tbody>\n</table>\n"
+}
+
+func (r *HtmlRenderer) renderRow(row *TableRow, align []Alignment, tag string) string {
+  result := "<tr>"
+
+  for i, cell := range row.Cells {
+    style := ""
+
+    if i < len(align) {
+      switch align[i] {
+      case ALIGN_LEFT:
+        style = ` style="text-align: left"`
+      case ALIGN_CENTER:
+        style = ` style="text-align: center"`
+      case ALIGN_RIGHT:
+        style = ` style="text-align: right"`
+      }
+    }
+
+    result += "<" + tag + style + ">" + r.renderInline(cell.Content) + "</" + tag + ">"
+  }
+
+  return result + "</tr>\n"
+}
+
+func (r *HtmlRenderer) renderInline(nodes []Node) string {
+  result := ""
+
+  for _, n := range nodes {
+    switch v := n.(type) {
+    case *Text:
+      result += html.EscapeString(v.Text)
+    case *Code:
+      result += "<code>" + html.EscapeString(v.Text) + "</code>"
+    case *LineBreak:
+      result += "<br>\n"
+    case *Link:
+      result += r.renderLink(v)
+    }
+  }
+
+  return result
+}
+
+func (r *HtmlRenderer) renderLink(l *Link) string {
+  url := ""
+
+  if r.LinkURL != nil {
+    url = r.LinkURL(l)
+  } else {
+    url = SafeURL(l)
+  }
+
+  title := html.EscapeString(l.Title)
+
+  if l.Type == LNK_UNRESOLVED {
+    return `<span class="unresolved">` + title + "</span>"
+  }
+
+  if url == "" {
+    return title
+  }
+
+  if l.Type == LNK_IMAGE {
+    return fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(url), title)
+  }
+
+  return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), title)
+}
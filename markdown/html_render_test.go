@@ -0,0 +1,68 @@
+package markdown
+
+import (
+  "testing"
+)
+
+func TestHtmlRender(t *testing.T) {
+  cases := []struct{
+    name string
+    markdown string
+    expected string
+  }{
+    {
+      name: "headings and paragraphs",
+      markdown: "# Title\nSome <b>text</b>\n## Sub",
+      expected: "<h1 id=\"heading-0\">Title</h1>\n<p>Some &lt;b&gt;text&lt;/b&gt;</p>\n<h2 id=\"heading-1\">Sub</h2>\n",
+    },
+    {
+      name: "lists and tasks",
+      markdown: " - [ ] one\n   - [x] two\n",
+      expected: "<ul>\n<li><input type=\"checkbox\" disabled> one\n<ul>\n<li><input type=\"checkbox\" checked disabled> two</li>\n</ul>\n</li>\n</ul>\n",
+    },
+    {
+      name: "code blocks",
+      markdown: "```go\nif a < b {}\n```\n",
+      expected: "<pre><code class=\"language-go\">if a &lt; b {}</code></pre>\n",
+    },
+    {
+      name: "safe links only",
+      markdown: "[web](https://example.com) [bad](javascript:alert) [note](zk:123)",
+      expected: "<p><a href=\"https://example.com\">web</a> bad note</p>\n",
+    },
+    {
+      name: "tables",
+      markdown: "| a | b |\n|:--|--:|\n| 1 | `2` |\n",
+      expected: "<table>\n<thead>\n<tr><th style=\"text-align: left\">a</th><th style=\"text-align: right\">b</th></tr>\n</thead>\n<tbody>\n<tr><td style=\"text-align: left\">1</td><td style=\"text-align: right\"><code>2</code></td></tr>\n</tbody>\n</table>\n",
+    },
+  }
+
+  for _, c := range cases {
+    t.Run(c.name, func(t *testing.T) {
+      got := NewHtmlRenderer().Render(Parse(c.markdown))
+
+      if got != c.expected {
+        t.Errorf("Expected %q, got %q", c.expected, got)
+      }
+    })
+  }
+
+  t.Run("uses LinkURL", func(t *testing.T) {
+    r := NewHtmlRenderer()
+    r.LinkURL = func(l *Link) string {
+      if l.Type == LNK_ZK {
+        return "/notes/" + l.Target
+      }
+      return ""
+    }
+
+    doc := Parse("[[missing]] [a \"note\"](zk:1)")
+    ResolveWikiLinks(doc, func(target string) (string, bool) { return "", false })
+
+    expected := "<p><span class=\"unresolved\">missing</span> <a href=\"/notes/1\">a &#34;note&#34;</a></p>\n"
+
+    if got := r.Render(doc); got != expected {
+      t.Errorf("Expected %q, got %q", expected, got)
+    }
+  })
+}
@@ -1,19 +1,47 @@
 package markdown
 
-func MarkdownToTui(markdown string) (string, []link) {
+func MarkdownToTui(markdown string) (string, []*Link) {
+  doc := Parse(markdown)
+  result := NewTuiRenderer().Render(doc)
+
+  return result, doc.Links()
+}
+
+// ExtractLinks returns the links in markdown in the order of their rendered
+// regions. Wikilinks are resolved with resolve when it is set.
+func ExtractLinks(markdown string, resolve Resolver) []*Link {
+  doc := Parse(markdown)
+
+  if resolve != nil {
+    ResolveWikiLinks(doc, resolve)
+  }
+
+  return doc.Links()
+}
+
+// ReplaceLinks returns markdown with links swapped for the text replace returns
+// for them. replace gets each link with the markdown it was parsed from and
+// returns false to keep the link as it is. Links in code are left alone.
+func ReplaceLinks(markdown string, replace func(l *Link, source string) (string, bool)) string {
   result := ""
+  last := 0
+
+  for _, l := range Parse(markdown).Links() {
+    start, end := l.Pos().Offset, l.End().Offset
+
+    if start < last {
+      continue
+    }
 
-  tokenizer := newParser(markdown)
-  parser := NewTokenParser(&tokenizer)
-  
+    text, ok := replace(l, markdown[start:end])
 
-  for {
-    if parser.AtEnd() {
-      break
+    if !ok {
+      continue
     }
 
-    result += parser.ParseToken()
+    result += markdown[last:start] + text
+    last = end
   }
 
-  return result, tokenizer.Links()
+  return result + markdown[last:]
 }
@@ -14,15 +14,19 @@ const (
   TOK_ORDEREDITEM
   TOK_LINK
   TOK_CODEBLOCK
+  TOK_TABLE
 )
 
 const (
-  LNK_URL linkType = iota
+  LNK_URL LinkType = iota
   LNK_ZK
   LNK_ZKA
   LNK_REPORT
   LNK_EMPTY
   LNK_IMAGE
+  LNK_WIKI
+  LNK_UNRESOLVED
+  LNK_EMBED
 )
 
 const (
@@ -30,15 +34,24 @@ const (
   TXT_CODE
 )
 
+const (
+  ALIGN_NONE Alignment = iota
+  ALIGN_LEFT
+  ALIGN_CENTER
+  ALIGN_RIGHT
+)
+
 type tokenType int
-type linkType int
+type LinkType int
 type textFormat int
+type Alignment int
 
 type link struct {
-  Type linkType
+  Type LinkType
   Target string
   Index int
   Title string
+  Section string
 }
 
 type parser struct {
@@ -62,6 +75,20 @@ type token struct {
   Text string
   Format textFormat
   Language string
+  Align []Alignment
+  Rows [][]tableCell
+  Task bool
+  Checked bool
+  TaskPos int
+  Pos int
+  End int
+}
+
+// tableCell is a single cell of a TOK_TABLE token holding the inline tokens of the cell.
+type tableCell struct {
+  Tokens []token
+  Pos int
+  End int
 }
 
 func newParser(markdown string) parser {
@@ -73,7 +100,7 @@ func newParser(markdown string) parser {
 }
 
 func (p *parser) peekChar(length int) string {
-  if len(p.text) <= p.position + length {
+  if len(p.text) < p.position + length {
     return ""
   }
     
@@ -112,6 +139,10 @@ func (p *parser) readToNextToken() string {
 
   link := strings.Index(txt, "[")
 
+  if link > 1 && txt[link - 1] == '!' {
+    link--
+  }
+
   if link > 0 {
     txt = txt[:link]
   }
@@ -157,13 +188,17 @@ func(p *parser) parseBulletPoints() (bool, token) {
     for _, b := range []string{"-", "+", "*"} {
       if p.peekChar(i * 2 + 3) == strings.Repeat( " ", i * 2) + " " + b + " " {
         p.advance(i * 2 + 3)
+        tok := p.parseTaskMarker()
         txt := p.readToNextToken()
-        if txt[0] == '[' {
+        if len(txt) > 0 && txt[0] == '[' {
           txt = ""
         }
         p.advance(len(txt))
-  
-        return true, token{ Level: i + 1, Text: txt, Type: TOK_BULLET}
+
+        tok.Level = i + 1
+        tok.Text = txt
+        tok.Type = TOK_BULLET
+        return true, tok
       }
     }
   }
@@ -172,6 +207,30 @@ func(p *parser) parseBulletPoints() (bool, token) {
   return false, token{}
 }
 
+// parseTaskMarker eats a GFM task checkbox at the start of a list item and
+// returns a token with the task fields set.
+func(p *parser) parseTaskMarker() token {
+  box := p.peekChar(3)
+
+  if box != "[ ]" && box != "[x]" && box != "[X]" {
+    return token{}
+  }
+
+  after := p.peekChar(4)
+  if after != "" && after[3] != ' ' && after[3] != '\n' {
+    return token{}
+  }
+
+  tok := token{Task: true, Checked: box != "[ ]", TaskPos: p.position}
+  p.advance(3)
+
+  if p.peekChar(1) == " " {
+    p.advance(1)
+  }
+
+  return tok
+}
+
 func(p *parser) parseOrderedList() (bool, token) {
   if !p.startOfLine {
     return false, token{}
@@ -180,9 +239,17 @@ func(p *parser) parseOrderedList() (bool, token) {
   for i := 0; i < 3; i++ {
     if p.peekChar(i * 2 + 4) == strings.Repeat(" ", i * 2) + " 1. " {
       p.advance(i * 2 + 4)
+      tok := p.parseTaskMarker()
       txt := p.readToNextToken()
+      if len(txt) > 0 && txt[0] == '[' {
+        txt = ""
+      }
       p.advance(len(txt))
-      return true, token{ Level: i + 1, Text: txt, Type: TOK_ORDEREDITEM}
+
+      tok.Level = i + 1
+      tok.Text = txt
+      tok.Type = TOK_ORDEREDITEM
+      return true, tok
     }
   }
   return false, token{}
@@ -208,6 +275,10 @@ func(p *parser) parseCodeBlock() (bool, token) {
       p.advance(len(lang) + 1)
 
       for {
+        if p.position >= len(p.text) {
+          break
+        }
+
         line := p.readToEolNoChecking()
         p.advance(len(line) + 1) // eating line breaks
 
@@ -224,6 +295,213 @@ func(p *parser) parseCodeBlock() (bool, token) {
   return false, token{}
 }
 
+func(p *parser) parseTable() (bool, token) {
+  if !p.startOfLine {
+    return false, token{}
+  }
+
+  header := p.readToEolNoChecking()
+  delimStart := p.position + len(header) + 1
+
+  if !strings.Contains(header, "|") || delimStart >= len(p.text) {
+    return false, token{}
+  }
+
+  delim := p.text[delimStart:]
+  if idx := strings.Index(delim, "\n"); idx != -1 {
+    delim = delim[:idx]
+  }
+
+  align, ok := parseTableAlignment(delim)
+  headerCells := splitTableRow(header)
+
+  if !ok || len(align) != len(headerCells) || !strings.Contains(delim, "|") {
+    return false, token{}
+  }
+
+  start := p.position
+  rows := [][]tableCell{p.parseTableRow(header, headerCells, start)}
+  p.advance(len(header) + 1 + len(delim))
+
+  for p.peekChar(1) == "\n" {
+    p.advance(1)
+    line := p.readToEolNoChecking()
+
+    if !strings.Contains(line, "|") || strings.TrimSpace(line) == "" {
+      p.advance(-1)
+      break
+    }
+
+    rows = append(rows, p.parseTableRow(line, splitTableRow(line), p.position))
+    p.advance(len(line))
+  }
+
+  return true, token{Type: TOK_TABLE, Align: align, Rows: rows}
+}
+
+// parseTableRow tokenizes the inline content of each cell. Link ids carry on
+// from the main parser so they stay unique across the document.
+func (p *parser) parseTableRow(line string, cells [][]int, offset int) []tableCell {
+  result := make([]tableCell, 0, len(cells))
+
+  for _, c := range cells {
+    sub := newParser(line[c[0]:c[1]])
+    sub.startOfLine = false
+    sub.nextLinkId = p.nextLinkId
+
+    cell := tableCell{Pos: offset + c[0], End: offset + c[1]}
+
+    for {
+      tok := sub.NextToken()
+
+      if tok.Type == TOK_EOF || (tok.Type == TOK_TEXT && tok.Format == TXT_PLAIN && tok.Text == "") {
+        break
+      }
+
+      tok.Text = strings.ReplaceAll(tok.Text, "\\|", "|")
+      tok.Pos += cell.Pos
+      tok.End += cell.Pos
+      cell.Tokens = append(cell.Tokens, tok)
+    }
+
+    p.links = append(p.links, sub.links...)
+    p.nextLinkId = sub.nextLinkId
+    result = append(result, cell)
+  }
+
+  return result
+}
+
+// splitTableRow returns the start and end offsets of each trimmed cell in a
+// pipe table row. Escaped pipes and pipes inside code spans don't split cells.
+func splitTableRow(line string) [][]int {
+  result := make([][]int, 0)
+  start := 0
+  inCode := false
+
+  trimmed := strings.TrimRight(line, " \t")
+  if strings.HasSuffix(trimmed, "|") && !strings.HasSuffix(trimmed, "\\|") {
+    trimmed = trimmed[:len(trimmed) - 1]
+  }
+
+  lead := len(trimmed) - len(strings.TrimLeft(trimmed, " \t"))
+  if lead < len(trimmed) && trimmed[lead] == '|' {
+    start = lead + 1
+  }
+
+  addCell := func(end int) {
+    s := start
+    for s < end && (trimmed[s] == ' ' || trimmed[s] == '\t') {
+      s++
+    }
+
+    e := end
+    for e > s && (trimmed[e - 1] == ' ' || trimmed[e - 1] == '\t') {
+      e--
+    }
+
+    result = append(result, []int{s, e})
+  }
+
+  for i := start; i < len(trimmed); i++ {
+    switch trimmed[i] {
+    case '\\':
+      i++
+    case '`':
+      inCode = !inCode
+    case '|':
+      if !inCode {
+        addCell(i)
+        start = i + 1
+      }
+    }
+  }
+
+  addCell(len(trimmed))
+
+  return result
+}
+
+func parseTableAlignment(line string) ([]Alignment, bool) {
+  result := make([]Alignment, 0)
+
+  if !strings.Contains(line, "-") {
+    return result, false
+  }
+
+  for _, c := range splitTableRow(line) {
+    cell := line[c[0]:c[1]]
+
+    if strings.Trim(cell, ":-") != "" || !strings.Contains(cell, "-") {
+      return result, false
+    }
+
+    left := strings.HasPrefix(cell, ":")
+    right := strings.HasSuffix(cell, ":")
+
+    switch {
+    case left && right:
+      result = append(result, ALIGN_CENTER)
+    case left:
+      result = append(result, ALIGN_LEFT)
+    case right:
+      result = append(result, ALIGN_RIGHT)
+    default:
+      result = append(result, ALIGN_NONE)
+    }
+  }
+
+  return result, true
+}
+
+// parseWikiLink reads [[target|title]] links and ![[target#section]] embeds.
+func(p *parser) parseWikiLink() (bool, token) {
+  embed := p.peekChar(3) == "![["
+
+  if p.peekChar(2) != "[[" && !embed {
+    return false, token{}
+  }
+
+  line := p.readToEolNoChecking()
+  start := 2
+
+  if embed {
+    start = 3
+  }
+
+  end := strings.Index(line, "]]")
+
+  if end <= start {
+    return false, token{}
+  }
+
+  target := line[start:end]
+  title := target
+  section := ""
+
+  if idx := strings.Index(target, "|"); idx != -1 {
+    title = target[idx + 1:]
+    target = target[:idx]
+  }
+
+  if idx := strings.Index(target, "#"); idx != -1 {
+    section = strings.TrimSpace(target[idx + 1:])
+    target = target[:idx]
+  }
+
+  typ := LNK_WIKI
+
+  if embed {
+    typ = LNK_EMBED
+  }
+
+  p.advance(end + 2)
+  id := p.nextLinkId
+  p.nextLinkId++
+  p.links = append(p.links, link{Title: strings.TrimSpace(title), Target: strings.TrimSpace(target), Section: section, Type: typ, Index: id})
+  return true, token{ Type: TOK_LINK, Text: strconv.Itoa(id)}
+}
+
 func(p *parser) parseLink() (bool, token) {
   if p.peekChar(1) == "[" || p.peekChar(2) == "![" {
     isImage := false
@@ -244,17 +522,20 @@ func(p *parser) parseLink() (bool, token) {
       url := txt[openParen + 1:closeParen]
       urltype := LNK_URL
 
-      if len(url) > 3 && url[:3] == "zk:" {
+      if isImage {
+        // Images keep the raw url so zka: attachments can still be found.
+        urltype = LNK_IMAGE
+      } else if len(url) > 3 && url[:3] == "zk:" {
         url = url[3:]
         urltype = LNK_ZK
       }
 
-      if len(url) > 4 && url[:4] == "zka:" {
+      if urltype == LNK_URL && len(url) > 4 && url[:4] == "zka:" {
         url = url[4:]
         urltype = LNK_ZKA
       }
 
-      if len(url) > 3 && url[:3] == "rp:" {
+      if urltype == LNK_URL && len(url) > 3 && url[:3] == "rp:" {
         url = url[3:]
         urltype = LNK_REPORT
       }
@@ -264,10 +545,6 @@ func(p *parser) parseLink() (bool, token) {
         url = ""
       }
 
-      if isImage {
-        urltype = LNK_IMAGE
-      }
-
       p.advance(closeParen + 1)
       id := p.nextLinkId
       p.nextLinkId++
@@ -278,19 +555,21 @@ func(p *parser) parseLink() (bool, token) {
   return false, token{}
 }
 
+// parseFormatedString reads a code span up to the closing backtick on the same
+// line, so links inside code spans stay as text.
 func(p *parser) parseFormatedString() (bool, token) {
   if p.peekChar(1) == "`" {
-    txt := p.readToNextToken()
-    full := p.peekChar(len(txt) + 1)
-    full_len := len(full) - 1
-    if full_len < 0 {
-      full_len = 0
+    line := p.text[p.position + 1:]
+
+    if idx := strings.Index(line, "\n"); idx != -1 {
+      line = line[:idx]
     }
 
-    if full[full_len:] == "`" {
-      txt = txt[1:]
-      p.advance(len(full))
-      return true, token{ Type: TOK_TEXT, Format: TXT_CODE, Text: txt}
+    end := strings.Index(line, "`")
+
+    if end != -1 {
+      p.advance(end + 2)
+      return true, token{ Type: TOK_TEXT, Format: TXT_CODE, Text: line[:end]}
     }
   }
   return false, token{}
@@ -319,6 +598,8 @@ func(p *parser) parseText() (bool, token) {
 }
 
 func(p *parser) NextToken() token {
+  start := p.position
+
   return func(f []func()(bool, token))(token) {
     for _, fn := range f {
       handled, tok := fn()
@@ -327,6 +608,8 @@ func(p *parser) NextToken() token {
         if tok.Type != TOK_NEWLINE {
           p.startOfLine = false
         }
+        tok.Pos = start
+        tok.End = p.position
         return tok
       }
     }
@@ -339,6 +622,8 @@ func(p *parser) NextToken() token {
     p.parseBulletPoints,
     p.parseOrderedList,
     p.parseCodeBlock,
+    p.parseTable,
+    p.parseWikiLink,
     p.parseLink,
     p.parseFormatedString,
     p.parseText, //Keep this item at the bottom to catch all remaining text
@@ -251,7 +251,7 @@ func TestMarkdownParser(t *testing.T){
       markdown string
       types []tokenType
       text []string
-      linkTypes []linkType
+      linkTypes []LinkType
       linkTargets []string
       linkText []string
     }{
@@ -259,7 +259,7 @@ func TestMarkdownParser(t *testing.T){
         markdown : "[WebLink](http://www.google.com)",
         types : []tokenType{ TOK_LINK },
         text : []string { "0"},
-        linkTypes: []linkType { LNK_URL},
+        linkTypes: []LinkType { LNK_URL},
         linkTargets : []string{ "http://www.google.com"},
         linkText: []string{ "WebLink"},
       },
@@ -267,7 +267,7 @@ func TestMarkdownParser(t *testing.T){
         markdown : "[ZKLink](zk:1234)",
         types : []tokenType{ TOK_LINK },
         text : []string { "0"},
-        linkTypes: []linkType { LNK_ZK},
+        linkTypes: []LinkType { LNK_ZK},
         linkTargets : []string{ "1234"},
         linkText: []string{ "ZKLink"},
       },
@@ -275,7 +275,7 @@ func TestMarkdownParser(t *testing.T){
         markdown : "[ZKALink](zka:1234)",
         types : []tokenType{ TOK_LINK },
         text : []string { "0"},
-        linkTypes: []linkType { LNK_ZKA},
+        linkTypes: []LinkType { LNK_ZKA},
         linkTargets : []string{ "1234"},
         linkText: []string{ "ZKALink"},
       },
@@ -283,7 +283,7 @@ func TestMarkdownParser(t *testing.T){
         markdown : "[ReportLink](rp:1234)",
         types : []tokenType{ TOK_LINK },
         text : []string { "0"},
-        linkTypes: []linkType { LNK_REPORT},
+        linkTypes: []LinkType { LNK_REPORT},
         linkTargets : []string{ "1234"},
         linkText: []string{ "ReportLink"},
       },
@@ -291,7 +291,7 @@ func TestMarkdownParser(t *testing.T){
         markdown : "[EmptyLink]()",
         types : []tokenType{ TOK_LINK },
         text : []string { "0"},
-        linkTypes: []linkType { LNK_EMPTY},
+        linkTypes: []LinkType { LNK_EMPTY},
         linkTargets : []string{ ""},
         linkText: []string{ "EmptyLink"},
       },
@@ -299,7 +299,7 @@ func TestMarkdownParser(t *testing.T){
         markdown : "[EmptyLink]( )",
         types : []tokenType{ TOK_LINK },
         text : []string { "0"},
-        linkTypes: []linkType { LNK_EMPTY},
+        linkTypes: []LinkType { LNK_EMPTY},
         linkTargets : []string{ ""},
         linkText: []string{ "EmptyLink"},
       },
@@ -307,7 +307,7 @@ func TestMarkdownParser(t *testing.T){
         markdown: "![Image](test.jpg)",
         types: []tokenType{TOK_LINK},
         text: []string {"0"},
-        linkTypes: []linkType { LNK_IMAGE},
+        linkTypes: []LinkType { LNK_IMAGE},
         linkTargets: []string{"test.jpg"},
         linkText: []string{"Image"},
       },
@@ -315,7 +315,7 @@ func TestMarkdownParser(t *testing.T){
         markdown : "[WebLink](http://www.google.com)[AnotherLink](zk:1234)",
         types : []tokenType{ TOK_LINK, TOK_LINK },
         text : []string { "0", "1"},
-        linkTypes: []linkType { LNK_URL, LNK_ZK},
+        linkTypes: []LinkType { LNK_URL, LNK_ZK},
         linkTargets : []string{ "http://www.google.com", "1234"},
         linkText: []string{ "WebLink", "AnotherLink"},
       },
@@ -465,3 +465,69 @@ func TestMarkdownParser(t *testing.T){
  })
 }
 
+
+func TestTableParser(t *testing.T) {
+  t.Run("Can parse pipe tables", func(t *testing.T) {
+    markdown := "| Name | Link | Count |\n" +
+    "|:-----|:----:|------:|\n" +
+    "| a | [Note](zk:1) | `1` |\n" +
+    "| b \\| c | | 2 |\n" +
+    "after"
+
+    parser := newParser(markdown)
+    got := parser.NextToken()
+
+    if got.Type != TOK_TABLE {
+      t.Fatalf("Expected %+v, got %+v", TOK_TABLE, got.Type)
+    }
+
+    expectedAlign := []Alignment{ALIGN_LEFT, ALIGN_CENTER, ALIGN_RIGHT}
+    if !reflect.DeepEqual(expectedAlign, got.Align) {
+      t.Errorf("Expected alignment %+v, got %+v", expectedAlign, got.Align)
+    }
+
+    if len(got.Rows) != 3 {
+      t.Fatalf("Expected 3 rows, got %d", len(got.Rows))
+    }
+
+    link := got.Rows[1][1].Tokens[0]
+    if link.Type != TOK_LINK || parser.Links()[0].Target != "1" {
+      t.Errorf("Expected link in cell, got %+v", link)
+    }
+
+    code := got.Rows[1][2].Tokens[0]
+    if code.Format != TXT_CODE || code.Text != "1" {
+      t.Errorf("Expected code in cell, got %+v", code)
+    }
+
+    escaped := got.Rows[2][0].Tokens[0]
+    if escaped.Text != "b | c" {
+      t.Errorf("Expected escaped pipe to stay in cell, got '%s'", escaped.Text)
+    }
+
+    if len(got.Rows[2][1].Tokens) != 0 {
+      t.Errorf("Expected empty cell, got %+v", got.Rows[2][1].Tokens)
+    }
+
+    if parser.NextToken().Type != TOK_NEWLINE || parser.NextToken().Text != "after" {
+      t.Errorf("Expected table to stop before trailing text")
+    }
+  })
+
+  t.Run("Pipes without a delimiter row are text", func(t *testing.T) {
+    cases := []string{
+      "a | b\nc | d",
+      "a | b\n---",
+      "a | b\n|---|",
+    }
+
+    for _, c := range cases {
+      parser := newParser(c)
+      got := parser.NextToken()
+
+      if got.Type != TOK_TEXT {
+        t.Errorf("Expected %+v, got %+v for '%s'", TOK_TEXT, got.Type, c)
+      }
+    }
+  })
+}
@@ -0,0 +1,23 @@
+package markdown
+
+import "errors"
+
+// ToggleTask flips the checkbox of the task with the given index and returns
+// the updated markdown.
+func ToggleTask(markdown string, index int) (string, error) {
+  for _, task := range Parse(markdown).Tasks() {
+    if task.TaskIndex != index {
+      continue
+    }
+
+    mark := "x"
+    if task.Checked {
+      mark = " "
+    }
+
+    offset := task.TaskPos.Offset + 1
+    return markdown[:offset] + mark + markdown[offset + 1:], nil
+  }
+
+  return markdown, errors.New("No task with that index found!")
+}
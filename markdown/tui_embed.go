@@ -0,0 +1,94 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EmbedLoader returns the parsed document and title of the note with the given
+// id. Wikilinks in the document should already be resolved.
+type EmbedLoader func(id string) (*Document, string, bool)
+
+// renderEmbed renders a resolved ![[id]] embed as a box holding the embedded
+// note, or the section of it named after the #. It returns false when the link
+// should render as a plain link instead, which is the case for missing notes or
+// sections, embeds that would include themselves and embeds past EmbedDepth.
+func (r *TuiRenderer) renderEmbed(l *Link) (string, bool) {
+  if !l.Embed || l.Type != LNK_ZK || r.Embeds == nil {
+    return "", false
+  }
+
+  chain := append([]string{}, r.embedding...)
+
+  if len(chain) == 0 && r.NoteId != "" {
+    chain = append(chain, r.NoteId)
+  }
+
+  for _, id := range chain {
+    if id == l.Target {
+      return "", false
+    }
+  }
+
+  if len(r.embedding) >= r.EmbedDepth {
+    return "", false
+  }
+
+  doc, title, ok := r.Embeds(l.Target)
+
+  if !ok {
+    return "", false
+  }
+
+  blocks := doc.Content
+
+  if l.Section != "" {
+    if blocks, ok = doc.Section(l.Section); !ok {
+      return "", false
+    }
+  }
+
+  if l.Title != l.Target && !strings.HasPrefix(l.Title, l.Target + "#") {
+    title = l.Title
+  } else if l.Section != "" {
+    title += " › " + l.Section
+  }
+
+  sub := *r
+  sub.inert = true
+  sub.HeadingRegions = false
+  sub.Folded = nil
+  sub.embedding = append(chain, l.Target)
+
+  if sub.Width > 0 {
+    sub.Width -= 2
+  }
+
+  body := ""
+
+  if len(blocks) > 0 {
+    body = sub.renderBlocks(blocks, blocks[0].Pos().Line, blocks[len(blocks) - 1].End().Line)
+  }
+
+  header := ""
+
+  if !r.inert {
+    header += fmt.Sprintf(`["%d"]`, l.Index)
+  }
+
+  header += "[gray]╭─[-] " + r.linkIcon(l.Type) + "[" + r.Colors.Link + "]" + title + "[-:-:-]"
+
+  if !r.inert {
+    header += `[""]`
+  }
+
+  lines := []string{header}
+
+  for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+    lines = append(lines, "[gray]│[-] " + line)
+  }
+
+  lines = append(lines, "[gray]╰─[-]")
+
+  return strings.Join(lines, "\n"), true
+}
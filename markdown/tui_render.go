@@ -2,170 +2,296 @@ package markdown
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 )
 
-type tokenParser struct {
-  tok tokenizer
-  eof bool
-  level1Ordinal int
-  level2Ordinal int
-  level3Ordinal int
-  lastWasLF bool
+// TuiRenderer renders a Document into text with tview color and region tags.
+type TuiRenderer struct {
+  Theme Theme
+  Colors Colors
+  // LinkIcons replaces the icon shown in front of links of a type.
+  LinkIcons map[LinkType]string
+  LineNumbers bool
+  Width int
+  // HeadingRegions wraps each heading marker in a "heading-N" region so the
+  // viewer can scroll to it.
+  HeadingRegions bool
+  // Folded holds the indexes of headings whose sections are hidden.
+  Folded map[int]bool
+  // Embeds loads the notes behind ![[id]] embeds. Embeds render as plain
+  // links when it is nil.
+  Embeds EmbedLoader
+  // EmbedDepth limits how deeply embeds can nest.
+  EmbedDepth int
+  // NoteId is the id of the note being rendered so it can not embed itself.
+  NoteId string
+
+  // inert renders without regions, used for embedded notes so their links do
+  // not clash with the links of the note being viewed.
+  inert bool
+  embedding []string
 }
 
-func NewTokenParser(tok tokenizer) tokenParser {
-  return tokenParser{tok: tok}
+// Colors are the tview styles used for the parts of a note outside of code
+// blocks, like "blue::b".
+type Colors struct {
+  Heading string `yaml:"heading"`
+  Link string `yaml:"link"`
+  Unresolved string `yaml:"unresolved"`
+  Bullet string `yaml:"bullet"`
+  Code string `yaml:"code_span"`
 }
 
-func (p *tokenParser) AtEnd() bool {
-  return p.eof
+var DefaultColors = Colors{
+  Heading: "blue::b",
+  Link: "blue::u",
+  Unresolved: "red::u",
+  Bullet: "green",
+  Code: "green",
 }
 
-func (p *tokenParser) ParseToken() string {
+func NewTuiRenderer() *TuiRenderer {
+  return &TuiRenderer{Theme: Themes["default"], Colors: DefaultColors, EmbedDepth: 3}
+}
+
+func (r *TuiRenderer) Render(doc *Document) string {
+  return r.renderBlocks(doc.Content, 1, doc.End().Line)
+}
 
-  tok := p.tok.NextToken()
+// renderBlocks renders blocks starting at line and pads the output with new
+// lines up to end.
+func (r *TuiRenderer) renderBlocks(blocks []Node, line int, end int) string {
+  result := ""
+  heading := 0
+  foldLevel := 0
 
-  if tok.Type == TOK_EOF {
-    p.eof = true
-    return ""
-  }
+  for _, block := range blocks {
+    h, isHeading := block.(*Heading)
 
-  if tok.Type == TOK_NEWLINE {
-    if p.lastWasLF {
-      p.level1Ordinal = 0
-      p.level2Ordinal = 0
-      p.level3Ordinal = 0
+    // A folded section runs until the next heading of the same or higher level.
+    if foldLevel > 0 && (!isHeading || h.Level > foldLevel) {
+      if isHeading {
+        heading++
+      }
+      line = block.End().Line
+      continue
     }
+    foldLevel = 0
+
+    result += strings.Repeat("\n", block.Pos().Line - line)
 
-    p.lastWasLF = true
-    return "\n"
+    if isHeading {
+      result += r.renderHeading(h, heading)
+
+      if r.Folded[heading] {
+        foldLevel = h.Level
+      }
+      heading++
+    } else {
+      result += r.renderBlock(block)
+    }
+    line = block.End().Line
   }
 
-  p.lastWasLF = false
+  result += strings.Repeat("\n", end - line)
 
-  if tok.Type == TOK_HEADING {
+  return result
+}
 
-    result := "[blue::b]"
-    result += strings.Repeat("", tok.Level)
-    result += " " + tok.Text
-    result += "[-:-:-]"
+func (r *TuiRenderer) renderHeading(h *Heading, index int) string {
+  result := "[" + r.Colors.Heading + "]"
 
-    return result
+  if r.HeadingRegions {
+    result += fmt.Sprintf(`["heading-%d"]`, index)
   }
 
-  if tok.Type == TOK_TEXT {
-    result := ""
+  result += strings.Repeat("", h.Level)
 
-    if tok.Format == TXT_CODE {
-      result += "[green]"
-    }
+  if r.HeadingRegions {
+    result += `[""]`
+  }
 
-    result += tok.Text
+  result += " " + r.renderInline(h.Content)
 
-    if tok.Format != TXT_PLAIN {
-      result += "[-:-:-]"
-    }
+  if r.Folded[index] {
+    result += " [gray]…[-]"
+  }
 
-    //HACK: Bug where infinite empty text nodes are created.
-    if tok.Text == "" {
-      p.eof = true
-    }
+  result += "[-:-:-]"
+
+  return result
+}
+
+func (r *TuiRenderer) renderBlock(n Node) string {
+  switch v := n.(type) {
+  case *Paragraph:
+    return r.renderInline(v.Content)
+  case *List:
+    return r.renderList(v, []int{})
+  case *Table:
+    return r.renderTable(v)
+  case *CodeBlock:
+    result := fmt.Sprintf("[%s:%s]", r.Theme.Text, r.Theme.Background)
+
+    result += r.renderCode(v.Text, v.Language)
+    result += "\n[-:-:-]"
 
     return result
   }
 
-  if tok.Type == TOK_BULLET {
-    result := ""
-    switch tok.Level {
-    case 1:
-       result = " [green]ﱣ[-] "
-    case 2:
-       result = "   [green]ﱤ[-] "
-    case 3:
-       result = "     [green][-] "
-    }
+  return ""
+}
 
-    result += tok.Text
-    return result
+// renderList renders each item on its own line. Ordered items are numbered by
+// their path through the enclosing lists, missing parents count as 1.
+func (r *TuiRenderer) renderList(list *List, ordinals []int) string {
+  result := ""
+
+  for len(ordinals) < list.Level - 1 {
+    ordinals = append(ordinals, 1)
   }
 
-  if tok.Type == TOK_ORDEREDITEM {
-    result := ""
-    switch tok.Level {
-    case 1:
-      p.level1Ordinal++
-      p.level2Ordinal = 0
-      p.level3Ordinal = 0
-      result = fmt.Sprintf(" [green]%02d)[-] ", p.level1Ordinal)
-    case 2:
-      if p.level1Ordinal == 0 {
-        p.level1Ordinal = 1
-      }
-      p.level2Ordinal++
-      p.level3Ordinal = 0
-      result = fmt.Sprintf(" [green]%02d.%02d)[-] ", p.level1Ordinal, p.level2Ordinal)
-    case 3:
-      if p.level1Ordinal == 0 {
-        p.level1Ordinal = 1
+  for i, item := range list.Items {
+    if i > 0 {
+      result += "\n"
+    }
+
+    path := append(append([]int{}, ordinals...), i + 1)
+
+    if list.Ordered {
+      numbers := make([]string, 0, len(path))
+
+      for _, o := range path {
+        numbers = append(numbers, fmt.Sprintf("%02d", o))
       }
-      if p.level2Ordinal == 0 {
-        p.level2Ordinal = 1
+
+      result += fmt.Sprintf(" [%s]%s)[-] ", r.Colors.Bullet, strings.Join(numbers, "."))
+    } else {
+      switch item.Level {
+      case 1:
+         result += " [" + r.Colors.Bullet + "]ﱣ[-] "
+      case 2:
+         result += "   [" + r.Colors.Bullet + "]ﱤ[-] "
+      case 3:
+         result += "     [" + r.Colors.Bullet + "][-] "
       }
-      p.level3Ordinal++
+    }
 
-      result = fmt.Sprintf(" [green]%02d.%02d.%02d)[-] ", p.level1Ordinal, p.level2Ordinal, p.level3Ordinal)
+    if item.Task {
+      result += r.renderCheckbox(item)
     }
 
-    result += tok.Text
+    inline := make([]Node, 0)
+    sublists := make([]*List, 0)
 
-    return result
+    for _, child := range item.Content {
+      if sub, ok := child.(*List); ok {
+        sublists = append(sublists, sub)
+      } else {
+        inline = append(inline, child)
+      }
+    }
+
+    result += r.renderInline(inline)
+
+    for _, sub := range sublists {
+      result += "\n" + r.renderList(sub, path)
+    }
   }
 
-  if tok.Type == TOK_LINK {
-    links := p.tok.Links()
-    var tlink *link
+  return result
+}
 
-    for _, l := range links {
-      if strconv.Itoa(l.Index) == tok.Text {
-        tlink = &l
-        break
-      }
+// renderCheckbox renders the checkbox of a task item inside a region so the UI
+// can highlight and toggle it.
+func (r *TuiRenderer) renderCheckbox(item *ListItem) string {
+  if r.inert {
+    if item.Checked {
+      return "[green][-] "
     }
+    return "[yellow][-] "
+  }
+
+  if item.Checked {
+    return fmt.Sprintf(`["task-%d"][green][-][""] `, item.TaskIndex)
+  }
+
+  return fmt.Sprintf(`["task-%d"][yellow][-][""] `, item.TaskIndex)
+}
 
-    if tlink != nil { 
-      result := fmt.Sprintf(`["%d"]`, tlink.Index)
-
-      switch tlink.Type {
-      case LNK_URL:
-        result += ""
-      case LNK_ZK:
-        result += ""
-      case LNK_ZKA:
-        result += ""
-      case LNK_REPORT:
-        result += ""
-      case LNK_EMPTY:
-        result += ""
-      case LNK_IMAGE:
-        result += ""
+func (r *TuiRenderer) renderInline(nodes []Node) string {
+  result := ""
+
+  for _, n := range nodes {
+    switch v := n.(type) {
+    case *Text:
+      result += v.Text
+    case *Code:
+      result += "[" + r.Colors.Code + "]" + v.Text + "[-:-:-]"
+    case *LineBreak:
+      result += "\n"
+    case *Link:
+      if box, ok := r.renderEmbed(v); ok {
+        if result != "" && !strings.HasSuffix(result, "\n") {
+          result += "\n"
+        }
+        result += box
+      } else {
+        result += r.renderLink(v)
       }
-      result += "[blue::u]"
-      result += tlink.Title
-      result += `[-:-:-][""]`
-      return result
     }
+  }
+
+  return result
+}
+
+func (r *TuiRenderer) renderLink(l *Link) string {
+  result := ""
 
+  if !r.inert {
+    result += fmt.Sprintf(`["%d"]`, l.Index)
   }
+  result += r.linkIcon(l.Type)
 
-  if tok.Type == TOK_CODEBLOCK {
-    result := "[green:gray]"
+  if l.Type == LNK_UNRESOLVED {
+    result += "[" + r.Colors.Unresolved + "]"
+  } else {
+    result += "[" + r.Colors.Link + "]"
+  }
 
-    result += tok.Text 
-    result += "\n[-:-:-]"
+  result += l.Title
+  result += "[-:-:-]"
 
-    return result
+  if !r.inert {
+    result += `[""]`
+  }
+
+  return result
+}
+
+func (r *TuiRenderer) linkIcon(t LinkType) string {
+  if icon, ok := r.LinkIcons[t]; ok {
+    return icon
+  }
+
+  return LinkIcon(t)
+}
+
+// LinkIcon returns the default icon shown in front of links of a type.
+func LinkIcon(t LinkType) string {
+  switch t {
+  case LNK_URL:
+    return ""
+  case LNK_ZK, LNK_WIKI, LNK_EMBED:
+    return ""
+  case LNK_ZKA:
+    return ""
+  case LNK_REPORT:
+    return ""
+  case LNK_EMPTY, LNK_UNRESOLVED:
+    return ""
+  case LNK_IMAGE:
+    return ""
   }
 
   return ""
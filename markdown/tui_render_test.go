@@ -1,6 +1,9 @@
 package markdown
 
-import "testing"
+import (
+  "strings"
+  "testing"
+)
 
 type fakeTokenizer struct {
   toks []token
@@ -21,6 +24,31 @@ func(t *fakeTokenizer) Links() []link {
   return t.links
 }
 
+// renderTokens builds a document from toks and renders it. Tokens are laid out
+// in a fake source so the NEWLINE tokens decide which line each block is on.
+func renderTokens(toks []token, links []link) string {
+  text := ""
+  positioned := make([]token, 0, len(toks) + 1)
+
+  for _, tok := range toks {
+    tok.Pos = len(text)
+
+    if tok.Type == TOK_NEWLINE {
+      text += "\n"
+    } else {
+      text += " " + strings.ReplaceAll(tok.Text, "\n", " ")
+    }
+
+    tok.End = len(text)
+    positioned = append(positioned, tok)
+  }
+
+  positioned = append(positioned, token{Type: TOK_EOF, Pos: len(text), End: len(text)})
+
+  tok := fakeTokenizer{toks: positioned, links: links}
+  return NewTuiRenderer().Render(buildDocument(&tok, text))
+}
+
 func TestTuiRender(t *testing.T) {
   t.Run("Can convert tokens", func(t *testing.T) {
     cases := []struct{
@@ -160,9 +188,7 @@ func TestTuiRender(t *testing.T) {
     }
 
     for _, c := range cases {
-      tok := fakeTokenizer{ toks: []token{c.tok}}
-      parser := NewTokenParser(&tok)
-      got := parser.ParseToken()
+      got := renderTokens([]token{c.tok}, nil)
 
       if got != c.expected {
         t.Errorf("Expected '%+v' but got '%+v'", c.expected, got)
@@ -232,17 +258,12 @@ func TestTuiRender(t *testing.T) {
     }
 
     for _, c := range cases {
-      tok := fakeTokenizer { toks: c.tokens }
-      parser := NewTokenParser(&tok)
+      got := renderTokens(c.tokens, nil)
+      expected := strings.Join(c.text, "")
 
-      for i := 0; i < len(c.text); i++ {
-        got := parser.ParseToken()
-
-        if got != c.text[i] {
-          t.Errorf("Expected '%+v' but got '%+v' index %d", c.text[i], got, i)
-        }
+      if got != expected {
+        t.Errorf("Expected '%+v' but got '%+v'", expected, got)
       }
-
     }
 
   })
@@ -328,10 +349,7 @@ func TestTuiRender(t *testing.T) {
     }
 
     for _, c := range cases {
-      tok := fakeTokenizer { toks: []token{c.tok}, links: []link{c.link} }
-      parser := NewTokenParser(&tok)
-
-      got := parser.ParseToken()
+      got := renderTokens([]token{c.tok}, []link{c.link})
 
       if got != c.expected {
         t.Errorf("Expected '%+v' got '%+v'", c.expected, got) 
@@ -340,3 +358,192 @@ func TestTuiRender(t *testing.T) {
 
   })
 }
+
+func TestTuiTable(t *testing.T) {
+  t.Run("Renders aligned grids", func(t *testing.T) {
+    markdown := "| A | Bee | C |\n" +
+    "|---|:---:|--:|\n" +
+    "| one | x | 1 |"
+
+    expected := "┌─────┬─────┬───┐\n" +
+    "│ [::b]A  [::-] │ [::b]Bee[::-] │ [::b]C[::-] │\n" +
+    "├─────┼─────┼───┤\n" +
+    "│ one │  x  │ 1 │\n" +
+    "└─────┴─────┴───┘"
+
+    got, _ := MarkdownToTui(markdown)
+
+    if got != expected {
+      t.Errorf("Expected '%s', got '%s'", expected, got)
+    }
+  })
+
+  t.Run("Wraps wide cells to fit the width", func(t *testing.T) {
+    doc := Parse("| A | B |\n|---|---|\n| one two three | x |")
+    r := NewTuiRenderer()
+    r.Width = 15
+
+    expected := "┌─────────┬───┐\n" +
+    "│ [::b]A      [::-] │ [::b]B[::-] │\n" +
+    "├─────────┼───┤\n" +
+    "│ one two │ x │\n" +
+    "│ three   │   │\n" +
+    "└─────────┴───┘"
+
+    got := r.Render(doc)
+
+    if got != expected {
+      t.Errorf("Expected '%s', got '%s'", expected, got)
+    }
+  })
+
+  t.Run("Links in cells keep their region", func(t *testing.T) {
+    got, links := MarkdownToTui("Hey [a](zk:1)\n| A |\n|---|\n| [b](zk:2) |")
+
+    if len(links) != 2 || links[1].Index != 1 || links[1].Target != "2" {
+      t.Fatalf("Expected two links, got %+v", links)
+    }
+
+    if !strings.Contains(got, `["1"]`) {
+      t.Errorf("Expected link region in table, got '%s'", got)
+    }
+  })
+}
+
+func TestTuiFolding(t *testing.T) {
+  markdown := "# One\ntext\n## Two\nmore\n# Three\nend"
+
+  t.Run("Headings can be wrapped in regions", func(t *testing.T) {
+    r := NewTuiRenderer()
+    r.HeadingRegions = true
+
+    got := r.Render(Parse(markdown))
+
+    for _, region := range []string{`["heading-0"]`, `["heading-1"]`, `["heading-2"]`} {
+      if !strings.Contains(got, region) {
+        t.Errorf("Expected region %s in '%s'", region, got)
+      }
+    }
+  })
+
+  t.Run("Folded headings hide their section", func(t *testing.T) {
+    r := NewTuiRenderer()
+    r.Folded = map[int]bool{0: true}
+
+    got := r.Render(Parse(markdown))
+    lines := strings.Split(got, "\n")
+
+    if len(lines) != 3 {
+      t.Fatalf("Expected 3 lines, got '%s'", got)
+    }
+
+    if !strings.Contains(lines[0], "One [gray]…[-]") || !strings.Contains(lines[1], "Three") || lines[2] != "end" {
+      t.Errorf("Unexpected folded output '%s'", got)
+    }
+  })
+
+  t.Run("Folding a sub heading keeps the rest", func(t *testing.T) {
+    r := NewTuiRenderer()
+    r.Folded = map[int]bool{1: true}
+
+    got := r.Render(Parse(markdown))
+
+    if strings.Contains(got, "more") || !strings.Contains(got, "text") || !strings.Contains(got, "Three") {
+      t.Errorf("Unexpected folded output '%s'", got)
+    }
+  })
+}
+
+func TestTuiEmbed(t *testing.T) {
+  notes := map[string]string{
+    "a": "Top ![[b]]",
+    "b": "# Intro\nfrom b [x](zk:1)\n# Other\n![[a]]",
+    "c": "![[c]]",
+  }
+
+  load := func(id string) (*Document, string, bool) {
+    text, ok := notes[id]
+    if !ok {
+      return nil, "", false
+    }
+
+    doc := Parse(text)
+    ResolveWikiLinks(doc, func(target string) (string, bool) {
+      _, ok := notes[target]
+      return target, ok
+    })
+    return doc, "Note " + id, true
+  }
+
+  render := func(id string, text string) (string, []*Link) {
+    doc := Parse(text)
+    ResolveWikiLinks(doc, func(target string) (string, bool) {
+      _, ok := notes[target]
+      return target, ok
+    })
+
+    r := NewTuiRenderer()
+    r.Embeds = load
+    r.NoteId = id
+    return r.Render(doc), doc.Links()
+  }
+
+  t.Run("Parses embeds with sections", func(t *testing.T) {
+    links := Parse("![[b#Intro]] [[b]]").Links()
+
+    if !links[0].Embed || links[0].Target != "b" || links[0].Section != "Intro" || links[1].Embed {
+      t.Errorf("Unexpected links %+v %+v", links[0], links[1])
+    }
+  })
+
+  t.Run("Embeds render boxed and clickable", func(t *testing.T) {
+    got, links := render("", "See ![[b#Intro]]")
+
+    if len(links) != 1 || links[0].Type != LNK_ZK {
+      t.Fatalf("Expected one note link, got %+v", links)
+    }
+
+    lines := strings.Split(got, "\n")
+
+    if len(lines) != 5 || lines[0] != "See " || !strings.HasPrefix(lines[1], `["0"][gray]╭─[-]`) || !strings.Contains(lines[1], "Note b › Intro") {
+      t.Fatalf("Unexpected embed '%s'", got)
+    }
+
+    if !strings.Contains(lines[3], "from b") || strings.Contains(got, "Other") {
+      t.Errorf("Expected only the Intro section, got '%s'", got)
+    }
+
+    if strings.Count(got, `["0"]`) != 1 {
+      t.Errorf("Expected embedded links to have no regions, got '%s'", got)
+    }
+  })
+
+  t.Run("Stops cycles", func(t *testing.T) {
+    got, _ := render("a", notes["a"])
+
+    if strings.Count(got, "╭─") != 1 {
+      t.Errorf("Expected the cycle back to a to render as a link, got '%s'", got)
+    }
+
+    got, _ = render("c", notes["c"])
+
+    if strings.Contains(got, "╭─") {
+      t.Errorf("Expected a note not to embed itself, got '%s'", got)
+    }
+  })
+
+  t.Run("Limits depth", func(t *testing.T) {
+    doc := Parse("![[b]]")
+    ResolveWikiLinks(doc, func(target string) (string, bool) { return target, true })
+
+    r := NewTuiRenderer()
+    r.Embeds = load
+    r.EmbedDepth = 1
+
+    got := r.Render(doc)
+
+    if strings.Count(got, "╭─") != 1 {
+      t.Errorf("Expected one level of embeds, got '%s'", got)
+    }
+  })
+}
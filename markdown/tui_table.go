@@ -0,0 +1,278 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// cellWord is a single word of a table cell. Each word carries its own tags
+// so cells can be wrapped without breaking the markup.
+type cellWord struct {
+  prefix string
+  icon string
+  style string
+  text string
+  suffix string
+}
+
+func (r *TuiRenderer) cellWords(nodes []Node) []cellWord {
+  result := make([]cellWord, 0)
+
+  for _, n := range nodes {
+    switch v := n.(type) {
+    case *Text:
+      for _, w := range strings.Fields(v.Text) {
+        result = append(result, cellWord{text: w})
+      }
+    case *Code:
+      for _, w := range strings.Fields(v.Text) {
+        result = append(result, cellWord{style: "[" + r.Colors.Code + "]", text: w, suffix: "[-:-:-]"})
+      }
+    case *Link:
+      for i, w := range strings.Fields(v.Title) {
+        word := cellWord{prefix: fmt.Sprintf(`["%d"]`, v.Index), style: "[" + r.Colors.Link + "]", text: w, suffix: `[-:-:-][""]`}
+
+        if r.inert {
+          word.prefix = ""
+          word.suffix = "[-:-:-]"
+        }
+
+        if i == 0 {
+          word.icon = r.linkIcon(v.Type)
+        }
+
+        result = append(result, word)
+      }
+    }
+  }
+
+  return result
+}
+
+func (w cellWord) width() int {
+  return runewidth.StringWidth(w.icon) + runewidth.StringWidth(w.text)
+}
+
+func (w cellWord) String() string {
+  return w.prefix + w.icon + w.style + w.text + w.suffix
+}
+
+func wordsWidth(words []cellWord) int {
+  result := 0
+
+  for i, w := range words {
+    if i > 0 {
+      result++
+    }
+    result += w.width()
+  }
+
+  return result
+}
+
+// wrapWords breaks words into lines no wider than width. Words longer than the
+// width are split across lines.
+func wrapWords(words []cellWord, width int) [][]cellWord {
+  result := make([][]cellWord, 0)
+  line := make([]cellWord, 0)
+  lineWidth := 0
+
+  for _, w := range words {
+    ww := w.width()
+
+    if lineWidth > 0 && lineWidth + 1 + ww > width {
+      result = append(result, line)
+      line = make([]cellWord, 0)
+      lineWidth = 0
+    }
+
+    for ww > width && width > 0 {
+      head, tail := splitWidth(w.text, width - runewidth.StringWidth(w.icon))
+      first := w
+      first.text = head
+      result = append(result, []cellWord{first})
+      w.icon = ""
+      w.text = tail
+      ww = w.width()
+    }
+
+    if lineWidth > 0 {
+      lineWidth++
+    }
+
+    line = append(line, w)
+    lineWidth += ww
+  }
+
+  if len(line) > 0 || len(result) == 0 {
+    result = append(result, line)
+  }
+
+  return result
+}
+
+func splitWidth(text string, width int) (string, string) {
+  used := 0
+
+  for i, c := range text {
+    cw := runewidth.RuneWidth(c)
+
+    if used + cw > width && i > 0 {
+      return text[:i], text[i:]
+    }
+    used += cw
+  }
+
+  return text, ""
+}
+
+// tableWidths works out the width of each column, shrinking the widest column
+// until the table fits in the renderer width.
+func (r *TuiRenderer) tableWidths(cells [][][]cellWord, columns int) []int {
+  result := make([]int, columns)
+
+  for _, row := range cells {
+    for i, cell := range row {
+      if w := wordsWidth(cell); w > result[i] {
+        result[i] = w
+      }
+    }
+  }
+
+  for i := range result {
+    if result[i] == 0 {
+      result[i] = 1
+    }
+  }
+
+  if r.Width <= 0 {
+    return result
+  }
+
+  available := r.Width - columns * 3 - 1
+
+  for {
+    total := 0
+    widest := 0
+
+    for i, w := range result {
+      total += w
+
+      if w > result[widest] {
+        widest = i
+      }
+    }
+
+    if total <= available || result[widest] <= 3 {
+      break
+    }
+
+    result[widest]--
+  }
+
+  return result
+}
+
+func alignCell(words []cellWord, width int, align Alignment) string {
+  result := ""
+
+  for i, w := range words {
+    if i > 0 {
+      result += " "
+    }
+    result += w.String()
+  }
+
+  pad := width - wordsWidth(words)
+  if pad < 0 {
+    pad = 0
+  }
+
+  switch align {
+  case ALIGN_RIGHT:
+    return strings.Repeat(" ", pad) + result
+  case ALIGN_CENTER:
+    return strings.Repeat(" ", pad / 2) + result + strings.Repeat(" ", pad - pad / 2)
+  }
+
+  return result + strings.Repeat(" ", pad)
+}
+
+func tableBorder(widths []int, left string, middle string, right string) string {
+  parts := make([]string, 0, len(widths))
+
+  for _, w := range widths {
+    parts = append(parts, strings.Repeat("─", w + 2))
+  }
+
+  return left + strings.Join(parts, middle) + right
+}
+
+func (r *TuiRenderer) renderTable(table *Table) string {
+  columns := len(table.Align)
+  rows := make([]*TableRow, 0, len(table.Rows) + 1)
+
+  if table.Header != nil {
+    rows = append(rows, table.Header)
+  }
+  rows = append(rows, table.Rows...)
+
+  cells := make([][][]cellWord, 0, len(rows))
+
+  for _, row := range rows {
+    rowCells := make([][]cellWord, columns)
+
+    for i := 0; i < columns && i < len(row.Cells); i++ {
+      rowCells[i] = r.cellWords(row.Cells[i].Content)
+    }
+
+    cells = append(cells, rowCells)
+  }
+
+  widths := r.tableWidths(cells, columns)
+  lines := []string{tableBorder(widths, "┌", "┬", "┐")}
+
+  for i, row := range cells {
+    wrapped := make([][][]cellWord, columns)
+    height := 1
+
+    for c := 0; c < columns; c++ {
+      wrapped[c] = wrapWords(row[c], widths[c])
+
+      if len(wrapped[c]) > height {
+        height = len(wrapped[c])
+      }
+    }
+
+    for l := 0; l < height; l++ {
+      line := "│"
+
+      for c := 0; c < columns; c++ {
+        words := []cellWord{}
+        if l < len(wrapped[c]) {
+          words = wrapped[c][l]
+        }
+
+        text := alignCell(words, widths[c], table.Align[c])
+
+        if i == 0 && table.Header != nil {
+          text = "[::b]" + text + "[::-]"
+        }
+
+        line += " " + text + " │"
+      }
+
+      lines = append(lines, line)
+    }
+
+    if i == 0 && table.Header != nil {
+      lines = append(lines, tableBorder(widths, "├", "┼", "┤"))
+    }
+  }
+
+  lines = append(lines, tableBorder(widths, "└", "┴", "┘"))
+
+  return strings.Join(lines, "\n")
+}
@@ -0,0 +1,197 @@
+// Package notefile reads and writes the files kn keeps notes in. A note file
+// is markdown that starts with yaml front matter between two --- lines.
+package notefile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Header is the front matter of a note as it is written in the file.
+type Header struct {
+	Title   string   `yaml:"Title"`
+	Date    string   `yaml:"Date"`
+	Type    string   `yaml:"Type"`
+	State   string   `yaml:"Status"`
+	Tags    []string `yaml:"Tags,flow,omitempty"`
+	Aliases []string `yaml:"Aliases,flow,omitempty"`
+}
+
+// Parse reads the yaml of a note's front matter.
+func Parse(yamlText string) (Header, error) {
+	var header Header
+	err := yaml.Unmarshal([]byte(yamlText), &header)
+	return header, err
+}
+
+// Split separates the front matter of a note file from its body. The front
+// matter starts at a first line of --- and ends at the next line that is only
+// ---, so a --- inside a title or tag doesn't end it. ok is false when the
+// text has no closed front matter, body is then the whole text.
+func Split(text string) (yamlText string, body string, ok bool) {
+	lines := strings.SplitAfter(text, "\n")
+
+	if !isFence(lines[0]) {
+		return "", text, false
+	}
+
+	start := len(lines[0])
+	offset := start
+
+	for _, line := range lines[1:] {
+		if isFence(line) {
+			return text[start:offset], text[offset+len(line):], true
+		}
+
+		offset += len(line)
+	}
+
+	return "", text, false
+}
+
+// isFence reports if a line opens or closes front matter.
+func isFence(line string) bool {
+	return strings.TrimRight(line, "\r\n") == "---"
+}
+
+// Format returns the text of a note file. Values are quoted where yaml needs
+// them to be, so any title or tag reads back as it was written.
+func Format(header Header, body string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(header); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	buf.WriteString("---\n")
+	buf.WriteString(body)
+
+	return buf.Bytes(), nil
+}
+
+// Walk calls found for every markdown file under dir, skipping folders that
+// start with a dot. A note's id is its file name without the .md. When more
+// than one file has that name the one created first keeps it and the others
+// are named by their path from dir, so adding a file never changes the id of
+// a note that is already there. A later file at the top of dir can't be named
+// by its path, it is left out and returned with the other skipped paths.
+func Walk(dir string, found func(path string, id string)) ([]string, error) {
+	paths := make([]string, 0)
+	names := make(map[string][]string)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if strings.HasSuffix(info.Name(), ".md") {
+			name := strings.TrimSuffix(info.Name(), ".md")
+			paths = append(paths, path)
+			names[name] = append(names[name], path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	first := make(map[string]string)
+
+	for name, shared := range names {
+		if len(shared) > 1 {
+			first[name] = oldest(shared)
+		}
+	}
+
+	seen := make(map[string]bool)
+	skipped := make([]string, 0)
+
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".md")
+
+		if owner, ok := first[id]; ok && owner != path {
+			rel, err := filepath.Rel(dir, path)
+
+			if err != nil {
+				continue
+			}
+
+			id = filepath.ToSlash(strings.TrimSuffix(rel, ".md"))
+		}
+
+		if seen[id] || (first[id] != "" && first[id] != path) {
+			skipped = append(skipped, path)
+			continue
+		}
+
+		seen[id] = true
+		found(path, id)
+	}
+
+	return skipped, nil
+}
+
+// oldest returns the path of the note that was created first by the Date in
+// its front matter, or its modification time when that can't be read. Ties
+// go to the first path.
+func oldest(paths []string) string {
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+
+	result := ""
+	var resultTime time.Time
+
+	for _, path := range sorted {
+		created := createdAt(path)
+
+		if result == "" || created.Before(resultTime) {
+			result, resultTime = path, created
+		}
+	}
+
+	return result
+}
+
+// createdAt reads when the note in the file was created.
+func createdAt(path string) time.Time {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if yamlText, _, ok := Split(string(data)); ok {
+			if header, err := Parse(yamlText); err == nil {
+				if t, err := time.Parse(time.RFC822, header.Date); err == nil {
+					return t
+				}
+			}
+		}
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,176 @@
+package notefile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	header := Header{Title: "Hello", Date: "19 Oct 26 17:57 UTC", Type: "zettle", State: "new", Tags: []string{"a", "b"}}
+	expected := "---\nTitle: Hello\nDate: 19 Oct 26 17:57 UTC\nType: zettle\nStatus: new\nTags: [a, b]\n---\nBody\n"
+
+	data, err := Format(header, "Body\n")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	cases := []Header{
+		{Title: "Book: The Title"},
+		{Title: "Re: x", Type: "zettle", State: "new"},
+		{Title: "- x"},
+		{Title: "#1 thing # with a comment"},
+		{Title: "[draft] {x}"},
+		{Title: "2021"},
+		{Title: "yes"},
+		{Title: `"quoted" and 'single'`},
+		{Title: "plain", Tags: []string{"a: b", "c, d", "#e", "[f]"}, Aliases: []string{"Re: y", "null"}},
+		{Title: "Pros --- cons", Tags: []string{"---"}, Aliases: []string{"--- x"}},
+	}
+
+	for _, c := range cases {
+		data, err := Format(c, "body\n---\n")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		text, body, ok := Split(string(data))
+
+		if !ok || body != "body\n---\n" {
+			t.Errorf("Expected the body back from %q, got %q", data, body)
+		}
+
+		got, err := Parse(text)
+
+		if err != nil {
+			t.Errorf("Can't read back %q: %v", data, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(c, got) {
+			t.Errorf("Expected %+v, got %+v from %q", c, got, data)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		text string
+		yaml string
+		body string
+		ok   bool
+	}{
+		{text: "---\nTitle: x\n---\nbody\n", yaml: "Title: x\n", body: "body\n", ok: true},
+		{text: "---\r\nTitle: x\r\n---\r\nbody", yaml: "Title: x\r\n", body: "body", ok: true},
+		{text: "---\nTitle: a --- b\nTags: [---x]\n---\n", yaml: "Title: a --- b\nTags: [---x]\n", body: "", ok: true},
+		{text: "---\nTitle: x\n----\nbody\n---", yaml: "Title: x\n----\nbody\n", body: "", ok: true},
+		{text: "---\nTitle: x\n", body: "---\nTitle: x\n"},
+		{text: "# No front matter\n---\n", body: "# No front matter\n---\n"},
+		{text: "", body: ""},
+	}
+
+	for _, c := range cases {
+		yamlText, body, ok := Split(c.text)
+
+		if yamlText != c.yaml || body != c.body || ok != c.ok {
+			t.Errorf("%q: expected %q %q %v, got %q %q %v", c.text, c.yaml, c.body, c.ok, yamlText, body, ok)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, date string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0760); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte("---\nDate: "+date+"\n---\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	walk := func() (map[string]string, []string) {
+		ids := make(map[string]string)
+
+		skipped, err := Walk(dir, func(path string, id string) {
+			rel, _ := filepath.Rel(dir, path)
+
+			if other, ok := ids[id]; ok {
+				t.Errorf("%s and %s both have id %s", other, rel, id)
+			}
+
+			ids[id] = filepath.ToSlash(rel)
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, path := range skipped {
+			rel, _ := filepath.Rel(dir, path)
+			skipped[i] = filepath.ToSlash(rel)
+		}
+
+		return ids, skipped
+	}
+
+	write("x/foo.md", "01 Jan 21 10:00 UTC")
+	write("b/bar.md", "01 Jan 21 10:00 UTC")
+	write("c/baz.md", "01 Jan 21 10:00 UTC")
+	write("d/baz.md", "01 Jan 20 10:00 UTC")
+	write(".hidden/x.md", "")
+	write("e/notes.txt", "")
+
+	expected := map[string]string{"foo": "x/foo.md", "bar": "b/bar.md", "baz": "d/baz.md", "c/baz": "c/baz.md"}
+
+	if got, skipped := walk(); !reflect.DeepEqual(expected, got) || len(skipped) != 0 {
+		t.Errorf("Expected %v, got %v %v", expected, got, skipped)
+	}
+
+	t.Run("ids stay put when a newer duplicate is added", func(t *testing.T) {
+		write("a/foo.md", "01 Jan 22 10:00 UTC")
+		expected["a/foo"] = "a/foo.md"
+
+		if got, _ := walk(); !reflect.DeepEqual(expected, got) {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("newer duplicates at the top are skipped", func(t *testing.T) {
+		write("foo.md", "01 Jan 23 10:00 UTC")
+
+		if got, skipped := walk(); !reflect.DeepEqual(expected, got) || !reflect.DeepEqual(skipped, []string{"foo.md"}) {
+			t.Errorf("Expected %v, got %v skipping %v", expected, got, skipped)
+		}
+	})
+
+	t.Run("falls back to the modification time", func(t *testing.T) {
+		write("f/qux.md", "")
+		write("g/qux.md", "")
+
+		old := time.Unix(1000, 0)
+		if err := os.Chtimes(filepath.Join(dir, "g", "qux.md"), old, old); err != nil {
+			t.Fatal(err)
+		}
+
+		expected["qux"] = "g/qux.md"
+		expected["f/qux"] = "f/qux.md"
+
+		if got, _ := walk(); !reflect.DeepEqual(expected, got) {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+	})
+}
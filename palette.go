@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rivo/tview"
+
+	"github.com/wiltaylor/kn/fuzzy"
+)
+
+// Command palette
+var paletteLayout *tview.Grid
+var paletteField *tview.InputField
+var paletteList *tview.List
+var PaletteResults []*Action
+
+// InitPalette creates the command palette controls.
+func InitPalette() {
+	paletteField = tview.NewInputField()
+	paletteField.SetLabel(": ")
+	paletteField.SetChangedFunc(PaletteUpdate)
+
+	paletteList = tview.NewList()
+	paletteList.SetBorder(true)
+	paletteList.SetTitle("Commands")
+	paletteList.SetHighlightFullLine(true)
+
+	paletteLayout = tview.NewGrid()
+	paletteLayout.SetRows(1, 0)
+	paletteLayout.AddItem(paletteField, 0, 0, 1, 1, 1, 1, true)
+	paletteLayout.AddItem(paletteList, 1, 0, 1, 1, 1, 1, false)
+}
+
+// ShowPalette opens the command palette with an empty command.
+func ShowPalette() {
+	paletteField.SetText("")
+	PaletteUpdate("")
+	SwitchView(ViewModePalette)
+}
+
+// PaletteUpdate lists the actions whose name fuzzy matches the first word of
+// the command, best match first. The rest of the command are the arguments.
+func PaletteUpdate(text string) {
+	name := ""
+
+	if words := strings.Fields(text); len(words) > 0 {
+		name = words[0]
+	}
+
+	type scored struct {
+		action *Action
+		match  fuzzy.Match
+	}
+
+	matches := make([]scored, 0)
+
+	for _, action := range Actions {
+		if !action.bindable("main") || action.Name == "palette" {
+			continue
+		}
+
+		if m, ok := fuzzy.Find(name, action.Name); ok {
+			matches = append(matches, scored{action: action, match: m})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].match.Score > matches[j].match.Score
+	})
+
+	PaletteResults = make([]*Action, 0, len(matches))
+	paletteList.Clear()
+
+	for _, m := range matches {
+		PaletteResults = append(PaletteResults, m.action)
+
+		title := highlightMatches(m.action.Name, m.match.Positions)
+
+		if m.action.Args != "" {
+			title += " [gray]" + tview.Escape(m.action.Args) + "[-]"
+		}
+
+		if key := KeyText("main", m.action.Name); key != "" {
+			title += fmt.Sprintf("  [green]%s[-]", tview.Escape(key))
+		}
+
+		paletteList.AddItem(title, m.action.Help, 0, nil)
+	}
+}
+
+// MovePaletteSelection moves the selected command up or down, wrapping at
+// either end.
+func MovePaletteSelection(step int) {
+	count := paletteList.GetItemCount()
+
+	if count == 0 {
+		return
+	}
+
+	paletteList.SetCurrentItem((paletteList.GetCurrentItem() + step + count) % count)
+}
+
+// RunPaletteCommand runs the selected action with the words after the action
+// name as its arguments.
+func RunPaletteCommand() {
+	idx := paletteList.GetCurrentItem()
+
+	if idx < 0 || idx >= len(PaletteResults) {
+		return
+	}
+
+	action := PaletteResults[idx]
+	args := strings.Fields(paletteField.GetText())
+
+	if len(args) > 0 {
+		args = args[1:]
+	}
+
+	SwitchView(ViewModeMain)
+	action.Run(args)
+}
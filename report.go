@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/wiltaylor/kn/markdown"
 )
 
 func DashboardReport() NoteData {
@@ -33,29 +37,50 @@ KKKKKKKKK    KKKKKKKNNNNNNNN         NNNNNNN
 		logo += fmt.Sprintf(" - [%s](zk:%s)\n", note.Title, note.Id)
 	}
 
+	logo += "\n# Note Types\n"
+
+	for _, t := range NoteTypes() {
+		logo += fmt.Sprintf(" - [%s Notes](rp:type/%s)\n", strings.Title(t.Name), t.Name)
+	}
+
 	logo += `
 # Reports
- - [Literature Notes](rp:literature)
  - [Fleeting Notes](rp:fleeting)
  - [Unknown Notes](rp:unknown)
- - [New Zettles](rp:newzettle)
+ - [Open Tasks](rp:tasks)
 `
 
 	header := NoteHeader{Title: "Dashboard", Id: "", Type: ReportNote, Filename: "", Date: "", State: NewState}
-	result := NoteData{Header: header, RawText: logo, FormatedText: "", Links: make([]NoteLink, 0)}
+	result := NoteData{Header: header, RawText: logo, FormatedText: "", Links: make([]*markdown.Link, 0)}
 
 	ExtractLinks(&result)
 	return result
 }
 
+// ReportNames lists the reports that can be opened with OpenReport, without
+// the rp: prefix.
+func ReportNames() []string {
+	names := []string{"dashboard", "fleeting", "unknown", "tasks"}
+
+	for _, t := range NoteTypes() {
+		names = append(names, "type/"+t.Name)
+	}
+
+	return names
+}
+
 func OpenReport(path string) NoteData {
 
 	if path == "rp:dashboard" {
 		return DashboardReport()
 	}
 
+	if strings.HasPrefix(path, "rp:type/") {
+		return TypeReport(NoteType(path[len("rp:type/"):]))
+	}
+
 	if path == "rp:literature" {
-		return LiteratureNoteReport()
+		return TypeReport(LiteratureNote)
 	}
 
 	if path == "rp:fleeting" {
@@ -63,13 +88,17 @@ func OpenReport(path string) NoteData {
 	}
 
 	if path == "rp:newzettle" {
-		return NewZettleNotesReport()
+		return TypeReport(ZettleNote)
 	}
 
 	if path == "rp:unknown" {
 		return UnknownNotes()
 	}
 
+	if path == "rp:tasks" {
+		return TasksReport()
+	}
+
 	return DashboardReport()
 }
 
@@ -78,14 +107,15 @@ func FleetingNotesReport() NoteData {
 
 	text := "# Fleeting Notes:\n"
 
+	states := Workflow(FleetingNote)
 	for _, n := range notes {
-		if n.State != DoneState {
+		if n.State != states[len(states)-1] {
 			text += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
 		}
 	}
 
 	header := NoteHeader{Title: "Fleeting Notes", Id: "", Type: ReportNote, Filename: "", Date: "", State: NewState}
-	result := NoteData{Header: header, RawText: text, FormatedText: "", Links: make([]NoteLink, 0)}
+	result := NoteData{Header: header, RawText: text, FormatedText: "", Links: make([]*markdown.Link, 0)}
 
 	ExtractLinks(&result)
 	return result
@@ -93,7 +123,13 @@ func FleetingNotesReport() NoteData {
 
 func UnknownNotes() NoteData {
 
-	notes := FindNotes("", []NoteType{UnknownNote})
+	notes := make([]NoteHeader, 0)
+
+	for _, n := range AllNotes {
+		if _, ok := LookupType(n.Type); !ok {
+			notes = append(notes, n)
+		}
+	}
 
 	text := "# Unknown Note Types:\n"
 
@@ -101,69 +137,104 @@ func UnknownNotes() NoteData {
 		text += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
 	}
 
+	if len(DuplicateNotes) > 0 {
+		text += "\n# Duplicate Notes:\nThese files have the id of an older note, rename them to open them.\n"
+
+		for _, path := range DuplicateNotes {
+			if rel, err := filepath.Rel(NoteDirectory, path); err == nil {
+				path = rel
+			}
+
+			text += fmt.Sprintf(" - `%s`\n", filepath.ToSlash(path))
+		}
+	}
+
 	header := NoteHeader{Title: "Unknown Notes", Id: "", Type: ReportNote, Filename: "", Date: "", State: NewState}
-	result := NoteData{Header: header, RawText: text, FormatedText: "", Links: make([]NoteLink, 0)}
+	result := NoteData{Header: header, RawText: text, FormatedText: "", Links: make([]*markdown.Link, 0)}
 
 	ExtractLinks(&result)
 	return result
 
 }
 
-func NewZettleNotesReport() NoteData {
-	notes := FindNotes("", []NoteType{ZettleNote})
+// TypeReport lists the notes of a type under the states of its workflow.
+func TypeReport(t NoteType) NoteData {
+	notes := FindNotes("", []NoteType{t})
+	text := stateSections(notes, t)
 
-	text := "# New Zettle Notes:\n"
+	header := NoteHeader{Title: fmt.Sprintf("%s Notes", strings.Title(t.String())), Id: "", Type: ReportNote, Filename: "", Date: "", State: NewState}
+	result := NoteData{Header: header, RawText: text, FormatedText: "", Links: make([]*markdown.Link, 0)}
 
-	for _, n := range notes {
-		if n.State == NewState {
-			text += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
+	ExtractLinks(&result)
+	return result
+}
+
+// stateSections lists notes under a heading for each state in the workflow of
+// the type, followed by the notes in states outside of it.
+func stateSections(notes []NoteHeader, t NoteType) string {
+	text := ""
+
+	for _, state := range Workflow(t) {
+		text += fmt.Sprintf("# %s Notes\n", strings.Title(string(state)))
+
+		for _, n := range notes {
+			if n.State == state {
+				text += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
+			}
 		}
+
+		text += "\n"
 	}
 
-	text += "\n# Unknown State Notes:\n"
+	text += "# Unknown State Notes\n"
 	for _, n := range notes {
-		if n.State != NewState && n.State != GreenState {
+		if StateIndex(t, n.State) == -1 {
 			text += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
 		}
 	}
 
-	header := NoteHeader{Title: "Unknown Notes", Id: "", Type: ReportNote, Filename: "", Date: "", State: NewState}
-	result := NoteData{Header: header, RawText: text, FormatedText: "", Links: make([]NoteLink, 0)}
-
-	ExtractLinks(&result)
-	return result
+	return text
 }
 
-func LiteratureNoteReport() NoteData {
+func TasksReport() NoteData {
+	text := "# Open Tasks:\n"
 
-	litNotes := FindNotes("", []NoteType{LiteratureNote})
+	for _, n := range AllNotes {
+		note, err := GetNoteData(n)
 
-	text := "# Ready Notes\n"
+		if err != nil {
+			continue
+		}
 
-	for _, n := range litNotes {
-		if n.State == ReadyState {
-			text += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
+		tasks := make([]string, 0)
+
+		for _, task := range markdown.Parse(note.RawText).Tasks() {
+			if task.Checked {
+				continue
+			}
+
+			line := note.RawText[task.TaskPos.Offset+3:]
+			if idx := strings.Index(line, "\n"); idx != -1 {
+				line = line[:idx]
+			}
+
+			tasks = append(tasks, strings.TrimSpace(line))
 		}
-	}
 
-	text += "\n# New Notes\n"
-	for _, n := range litNotes {
-		if n.State == NewState {
-			text += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
+		if len(tasks) == 0 {
+			continue
 		}
-	}
 
-	text += "\n# Unknown Notes\n"
-	for _, n := range litNotes {
-		if n.State == UnknownState {
-			text += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
+		text += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
+
+		for _, t := range tasks {
+			text += fmt.Sprintf("   - [ ] %s\n", t)
 		}
 	}
 
-	header := NoteHeader{Title: "Literature Notes", Id: "", Type: ReportNote, Filename: "", Date: "", State: NewState}
-	result := NoteData{Header: header, RawText: text, FormatedText: "", Links: make([]NoteLink, 0)}
+	header := NoteHeader{Title: "Open Tasks", Id: "", Type: ReportNote, Filename: "", Date: "", State: NewState}
+	result := NoteData{Header: header, RawText: text, FormatedText: "", Links: make([]*markdown.Link, 0)}
 
 	ExtractLinks(&result)
-
 	return result
 }
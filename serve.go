@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/wiltaylor/kn/server"
+)
+
+// vaultStore serves the notes of the loaded vault through the data layer.
+// stamp is the NotesStamp of the notes when they were last read.
+type vaultStore struct {
+	stamp string
+}
+
+// ServeCommand runs kn serve.
+func ServeCommand(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "Address to listen on")
+	token := flags.String("token", os.Getenv("KN_SERVE_TOKEN"), "Token that allows writes, they are refused without one")
+	parseArgs(flags, args)
+
+	store := &vaultStore{}
+
+	if err := store.Refresh(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", NoteDirectory, err)
+		os.Exit(1)
+	}
+
+	handler := server.New(store)
+	handler.Token = *token
+
+	if *token == "" {
+		fmt.Printf("Serving %s read only on http://%s\n", NoteDirectory, *addr)
+	} else {
+		fmt.Printf("Serving %s on http://%s\n", NoteDirectory, *addr)
+	}
+
+	if err := http.ListenAndServe(*addr, handler); err != nil {
+		fmt.Fprintf(os.Stderr, "Serve failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func webNote(record NoteRecord) server.Note {
+	note := server.Note{
+		Id:      record.Id,
+		Path:    record.Path,
+		Title:   record.Title,
+		Date:    record.Date,
+		Type:    record.Type,
+		State:   record.State,
+		Tags:    record.Tags,
+		Aliases: record.Aliases,
+		Body:    record.Body,
+	}
+
+	for _, lnk := range record.Links {
+		note.Links = append(note.Links, server.Link(lnk))
+	}
+
+	return note
+}
+
+func webNotes(headers []NoteHeader) []server.Note {
+	result := make([]server.Note, 0, len(headers))
+
+	for _, header := range headers {
+		result = append(result, webNote(headerRecord(header)))
+	}
+
+	return result
+}
+
+// Refresh rereads the notes when a note file was added, removed or changed
+// since they were last read, so the link index survives between requests.
+func (v *vaultStore) Refresh() error {
+	stamp, err := NotesStamp()
+
+	if err != nil {
+		return err
+	}
+
+	if stamp == v.stamp {
+		return nil
+	}
+
+	if err := RefreshNotes(); err != nil {
+		return err
+	}
+
+	v.stamp = stamp
+	return nil
+}
+
+func (vaultStore) Notes() []server.Note {
+	notes := append([]NoteHeader{}, AllNotes...)
+	sort.SliceStable(notes, func(i, j int) bool { return NoteTime(notes[i]).After(NoteTime(notes[j])) })
+
+	return webNotes(notes)
+}
+
+func (vaultStore) Note(id string) (server.Note, bool) {
+	header, ok := findNote(id)
+
+	if !ok {
+		return server.Note{}, false
+	}
+
+	note, err := readWebNote(header)
+
+	if err != nil {
+		return server.Note{}, false
+	}
+
+	return note, true
+}
+
+// readWebNote reads a note with its body, links and version.
+func readWebNote(header NoteHeader) (server.Note, error) {
+	record, err := noteRecord(header)
+
+	if err != nil {
+		return server.Note{}, err
+	}
+
+	version, err := noteVersion(header.Filename)
+
+	if err != nil {
+		return server.Note{}, err
+	}
+
+	note := webNote(record)
+	note.Version = version
+
+	return note, nil
+}
+
+// noteVersion is a hash of the note file, it changes whenever the file does.
+func noteVersion(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+func (vaultStore) Search(query string, mode string, types []string) ([]server.Note, error) {
+	searchMode := SearchFuzzy
+
+	switch mode {
+	case "fuzzy":
+	case "regex":
+		searchMode = SearchRegex
+	case "exact":
+		searchMode = SearchExact
+	default:
+		return nil, fmt.Errorf("unknown search mode %s", mode)
+	}
+
+	noteTypes := make([]NoteType, 0)
+
+	for _, t := range types {
+		noteTypes = append(noteTypes, NoteType(t))
+	}
+
+	if len(noteTypes) == 0 {
+		seen := make(map[NoteType]bool)
+
+		for _, note := range AllNotes {
+			if !seen[note.Type] {
+				seen[note.Type] = true
+				noteTypes = append(noteTypes, note.Type)
+			}
+		}
+	}
+
+	results, err := SearchNotes(query, searchMode, noteTypes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]NoteHeader, 0, len(results))
+
+	for _, r := range results {
+		headers = append(headers, r.Note)
+	}
+
+	return webNotes(headers), nil
+}
+
+func (vaultStore) Backlinks(id string) []server.Note {
+	return webNotes(Backlinks(id))
+}
+
+func (vaultStore) Resolve(target string) (string, bool) {
+	return ResolveNoteId(target)
+}
+
+func (vaultStore) Reports() []string {
+	return ReportNames()
+}
+
+func (vaultStore) Report(name string) (server.Note, bool) {
+	for _, report := range ReportNames() {
+		if report == name {
+			data := OpenReport("rp:" + name)
+			return server.Note{Id: name, Title: data.Header.Title, Type: string(ReportNote), Body: data.RawText}, true
+		}
+	}
+
+	return server.Note{}, false
+}
+
+func (vaultStore) AttachmentDir() string {
+	return filepath.Join(NoteDirectory, ".attachments")
+}
+
+func (vaultStore) Create(input server.NoteInput) (server.Note, error) {
+	title := "New Note"
+	if input.Title != nil && strings.TrimSpace(*input.Title) != "" {
+		title = *input.Title
+	}
+
+	noteType := Settings.DefaultType
+	if input.Type != nil && *input.Type != "" {
+		noteType = NoteType(*input.Type)
+	}
+
+	if err := checkInput(noteType, input); err != nil {
+		return server.Note{}, err
+	}
+
+	note, err := NewNote(title, noteType)
+
+	if err != nil {
+		return server.Note{}, err
+	}
+
+	applyInput(&note, input)
+
+	if err := SaveNoteData(note); err != nil {
+		return server.Note{}, err
+	}
+
+	return readWebNote(note.Header)
+}
+
+func (vaultStore) Update(id string, version string, input server.NoteInput) (server.Note, error) {
+	header, ok := findNote(id)
+
+	if !ok {
+		return server.Note{}, server.ErrNotFound
+	}
+
+	if version != "" {
+		current, err := noteVersion(header.Filename)
+
+		if err != nil {
+			return server.Note{}, err
+		}
+
+		if current != version {
+			return server.Note{}, server.ErrConflict
+		}
+	}
+
+	note, err := GetNoteData(header)
+
+	if err != nil {
+		return server.Note{}, err
+	}
+
+	noteType := note.Header.Type
+	if input.Type != nil {
+		noteType = NoteType(*input.Type)
+	}
+
+	if err := checkInput(noteType, input); err != nil {
+		return server.Note{}, err
+	}
+
+	applyInput(&note, input)
+
+	if err := SaveNoteData(note); err != nil {
+		return server.Note{}, err
+	}
+
+	if err := RefreshNote(id); err != nil {
+		return server.Note{}, err
+	}
+
+	return readWebNote(note.Header)
+}
+
+func (vaultStore) Attach(name string, data io.Reader) (string, error) {
+	ext := filepath.Ext(filepath.Base(name))
+	id, err := SaveAttachment(ext, data)
+
+	return id + ext, err
+}
+
+// checkInput makes sure a write makes sense for the vault. The type has to be
+// registered and the state part of its workflow. Front matter is written with
+// yaml so any text reads back, but the title, tags and aliases are shown on
+// one line so they can't hold line breaks and tags and aliases can't be blank.
+func checkInput(noteType NoteType, input server.NoteInput) error {
+	if _, ok := LookupType(noteType); !ok {
+		return fmt.Errorf("%w: unknown type %s", server.ErrInvalid, noteType)
+	}
+
+	if input.State != nil && StateIndex(noteType, normalState(NoteState(*input.State))) == -1 {
+		return fmt.Errorf("%w: %s is not a state of %s notes", server.ErrInvalid, *input.State, noteType)
+	}
+
+	if input.Title != nil && strings.ContainsAny(*input.Title, "\r\n") {
+		return fmt.Errorf("%w: the title has to be one line", server.ErrInvalid)
+	}
+
+	for _, list := range []*[]string{input.Tags, input.Aliases} {
+		if list == nil {
+			continue
+		}
+
+		for _, item := range *list {
+			if strings.TrimSpace(item) == "" || strings.ContainsAny(item, "\r\n") {
+				return fmt.Errorf("%w: bad tag or alias %q", server.ErrInvalid, item)
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyInput copies the fields of a write onto a note.
+func applyInput(note *NoteData, input server.NoteInput) {
+	if input.Title != nil && strings.TrimSpace(*input.Title) != "" {
+		note.Header.Title = *input.Title
+	}
+
+	if input.Type != nil {
+		note.Header.Type = NoteType(*input.Type)
+	}
+
+	if input.State != nil {
+		note.Header.State = normalState(NoteState(*input.State))
+	}
+
+	if input.Tags != nil {
+		note.Header.Tags = *input.Tags
+	}
+
+	if input.Aliases != nil {
+		note.Header.Aliases = *input.Aliases
+	}
+
+	if input.Body != nil {
+		note.RawText = *input.Body
+	}
+}
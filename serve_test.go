@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/wiltaylor/kn/server"
+)
+
+// useVault points the data layer at an empty notes directory with the
+// default settings.
+func useVault(t *testing.T) {
+	NoteDirectory = t.TempDir()
+	Settings = DefaultConfig()
+
+	if err := RefreshNotes(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVaultStoreRoundTrip(t *testing.T) {
+	useVault(t)
+	store := &vaultStore{}
+
+	title := "Pros --- cons"
+	tags := []string{"---", "a --- b"}
+	body := "Body\n---\nmore\n"
+
+	created, err := store.Create(server.NoteInput{Title: &title, Tags: &tags, Body: &body})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := "Pros --- cons --- more"
+
+	if _, err := store.Update(created.Id, "", server.NoteInput{Title: &renamed}); err != nil {
+		t.Fatal(err)
+	}
+
+	header, ok := findNote(created.Id)
+
+	if !ok {
+		t.Fatalf("Expected %s to be listed, got %+v", created.Id, AllNotes)
+	}
+
+	if header.Title != renamed || !reflect.DeepEqual(header.Tags, tags) {
+		t.Errorf("Unexpected header %+v", header)
+	}
+
+	note, err := GetNoteData(header)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if note.RawText != body {
+		t.Errorf("Expected the body %q, got %q", body, note.RawText)
+	}
+}
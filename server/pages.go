@@ -0,0 +1,151 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// page is what the layout template needs to draw any page. Content is the
+// rendered body of a note or report.
+type page struct {
+	Title     string
+	Query     string
+	Mode      string
+	Note      *Note
+	Content   template.HTML
+	Notes     []Note
+	Backlinks []Note
+	Reports   []string
+	Error     string
+}
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"modes": func() []string { return []string{"fuzzy", "regex", "exact"} },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - kn</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 0 auto; padding: 1em; line-height: 1.5; }
+nav { display: flex; gap: 1em; align-items: center; border-bottom: 1px solid #ccc; padding-bottom: .5em; }
+nav form { margin-left: auto; }
+p { white-space: pre-wrap; }
+pre { background: #f4f4f4; padding: .5em; overflow-x: auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: .2em .5em; }
+img { max-width: 100%; }
+.meta { color: #666; font-size: .9em; }
+.tag { background: #eef; border-radius: 3px; padding: 0 .3em; }
+.unresolved { color: #c00; text-decoration: underline dotted; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<nav>
+<a href="/">Notes</a>
+<a href="/reports/dashboard">Dashboard</a>
+<form action="/search">
+<input name="q" value="{{.Query}}" placeholder="Search">
+<select name="mode">
+{{range $mode := modes}}<option{{if eq $mode $.Mode}} selected{{end}}>{{$mode}}</option>{{end}}
+</select>
+</form>
+</nav>
+{{with .Note}}
+<h1>{{.Title}}</h1>
+<p class="meta">{{.Id}}{{if .Date}} · {{.Date}}{{end}} · {{.Type}}{{if .State}} · {{.State}}{{end}}{{range .Tags}} <span class="tag">{{.}}</span>{{end}}</p>
+{{else}}
+<h1>{{.Title}}</h1>
+{{end}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{.Content}}
+{{if .Notes}}
+<ul>
+{{range .Notes}}<li><a href="/notes/{{.Id}}">{{.Title}}</a> <span class="meta">{{.Type}}{{if .Date}} · {{.Date}}{{end}}</span></li>
+{{end}}</ul>
+{{end}}
+{{if .Backlinks}}
+<h2>Backlinks</h2>
+<ul>
+{{range .Backlinks}}<li><a href="/notes/{{.Id}}">{{.Title}}</a></li>
+{{end}}</ul>
+{{end}}
+{{if .Reports}}
+<h2>Reports</h2>
+<ul>
+{{range .Reports}}<li><a href="/reports/{{.}}">{{.}}</a></li>
+{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
+
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, status int, p page) {
+	if p.Mode == "" {
+		p.Mode = "fuzzy"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := pageTemplate.Execute(w, p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleIndex lists every note, newest first, and the reports.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.writePage(w, r, http.StatusOK, page{Title: "Notes", Notes: s.store.Notes(), Reports: s.store.Reports()})
+}
+
+func (s *Server) handleNote(w http.ResponseWriter, r *http.Request) {
+	note, ok := s.store.Note(strings.TrimPrefix(r.URL.Path, "/notes/"))
+
+	if !ok {
+		s.writePage(w, r, http.StatusNotFound, page{Title: "Not found", Error: "There is no note " + strings.TrimPrefix(r.URL.Path, "/notes/")})
+		return
+	}
+
+	detail := s.detail(note)
+	s.writePage(w, r, http.StatusOK, page{Title: note.Title, Note: &note, Content: template.HTML(detail.Html), Backlinks: detail.Backlinks})
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	p := page{Title: "Search", Query: params.Get("q"), Mode: params.Get("mode")}
+
+	notes, err := s.query(params)
+
+	if err != nil {
+		p.Error = err.Error()
+		s.writePage(w, r, http.StatusBadRequest, p)
+		return
+	}
+
+	if len(notes) == 0 {
+		p.Error = "No notes found"
+	}
+
+	p.Notes = notes
+	s.writePage(w, r, http.StatusOK, p)
+}
+
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/reports/")
+	report, ok := s.store.Report(name)
+
+	if !ok {
+		s.writePage(w, r, http.StatusNotFound, page{Title: "Not found", Error: "There is no report " + name})
+		return
+	}
+
+	s.writePage(w, r, http.StatusOK, page{Title: report.Title, Content: s.render(report.Body)})
+}
@@ -0,0 +1,369 @@
+// Package server serves a vault over HTTP as a read only web UI and a JSON
+// API that can also create and update notes. It knows nothing about how notes
+// are stored, everything goes through a Store so the handlers can be tested
+// without a notes directory.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/wiltaylor/kn/markdown"
+)
+
+// Errors a Store returns from writes, the API answers them with 404, 412 and
+// 400.
+var (
+	ErrNotFound = errors.New("note not found")
+	ErrConflict = errors.New("note has changed")
+	ErrInvalid  = errors.New("invalid note")
+)
+
+// Note is a note as the server sees it. Body, Links and Version are only
+// filled in when a single note is read. Path is the file relative to the notes
+// directory and Version changes whenever the file does.
+type Note struct {
+	Id      string   `json:"id"`
+	Path    string   `json:"path"`
+	Title   string   `json:"title"`
+	Date    string   `json:"date"`
+	Type    string   `json:"type"`
+	State   string   `json:"state"`
+	Tags    []string `json:"tags"`
+	Aliases []string `json:"aliases"`
+	Body    string   `json:"body,omitempty"`
+	Links   []Link   `json:"links,omitempty"`
+	Version string   `json:"version,omitempty"`
+}
+
+// NoteInput is the body of a create or update request. Fields left out of an
+// update keep their value. Version is the version the update was based on, it
+// can also be sent in an If-Match header.
+type NoteInput struct {
+	Title   *string   `json:"title"`
+	Type    *string   `json:"type"`
+	State   *string   `json:"state"`
+	Tags    *[]string `json:"tags"`
+	Aliases *[]string `json:"aliases"`
+	Body    *string   `json:"body"`
+	Version string    `json:"version"`
+}
+
+// Link is an outgoing link of a note.
+type Link struct {
+	Type    string `json:"type"`
+	Target  string `json:"target"`
+	Title   string `json:"title"`
+	Section string `json:"section,omitempty"`
+	Embed   bool   `json:"embed,omitempty"`
+}
+
+// Store is the vault behind the server.
+type Store interface {
+	// Refresh rereads the notes so changes made outside the server show up. It
+	// runs before every request so it should be cheap when nothing changed.
+	Refresh() error
+	// Notes returns every note without its body, newest first.
+	Notes() []Note
+	// Note returns the note with the given id.
+	Note(id string) (Note, bool)
+	// Search returns the notes of the given types, or of any type when there
+	// are none, that match query. Mode is fuzzy, regex or exact.
+	Search(query string, mode string, types []string) ([]Note, error)
+	// Backlinks returns the notes that link to the note with the given id.
+	Backlinks(id string) []Note
+	// Resolve returns the id of the note a link target points at by id, title
+	// or alias.
+	Resolve(target string) (string, bool)
+	// Reports lists the names of the reports Report can open.
+	Reports() []string
+	// Report returns a generated report by name, like dashboard or type/map.
+	Report(name string) (Note, bool)
+	// AttachmentDir is the folder attachments are served from.
+	AttachmentDir() string
+	// Create writes a new note.
+	Create(input NoteInput) (Note, error)
+	// Update changes the note with the given id. It fails with ErrConflict when
+	// version is set and the note is no longer at that version.
+	Update(id string, version string, input NoteInput) (Note, error)
+	// Attach stores data as a new attachment with the extension of name and
+	// returns its file name.
+	Attach(name string, data io.Reader) (string, error)
+}
+
+// Server is an http.Handler for a Store. Requests are handled one at a time as
+// the data layer behind a Store is not safe to use concurrently, attachments
+// are served outside of the lock.
+type Server struct {
+	// Token has to be sent as a bearer token with every write. Writes are
+	// refused while it is empty.
+	Token string
+
+	store Store
+	mux   *http.ServeMux
+	mu    sync.Mutex
+}
+
+// routes maps request methods to their handlers. HEAD uses the GET handler.
+type routes map[string]http.HandlerFunc
+
+// noteDetail is a note read through the API with its rendered body and the
+// notes that link to it.
+type noteDetail struct {
+	Note
+	Html      string `json:"html"`
+	Backlinks []Note `json:"backlinks"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func New(store Store) *Server {
+	s := &Server{store: store, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("/", s.locked(routes{http.MethodGet: s.handleIndex}))
+	s.mux.HandleFunc("/notes/", s.locked(routes{http.MethodGet: s.handleNote}))
+	s.mux.HandleFunc("/search", s.locked(routes{http.MethodGet: s.handleSearch}))
+	s.mux.HandleFunc("/reports/", s.locked(routes{http.MethodGet: s.handleReport}))
+	s.mux.HandleFunc("/attachments/", s.handleAttachment)
+	s.mux.HandleFunc("/api/notes", s.locked(routes{http.MethodGet: s.handleApiNotes, http.MethodPost: s.authorized(s.handleCreate)}))
+	s.mux.HandleFunc("/api/notes/", s.locked(routes{http.MethodGet: s.handleApiNote, http.MethodPut: s.authorized(s.handleUpdate)}))
+	s.mux.HandleFunc("/api/reports", s.locked(routes{http.MethodGet: s.handleApiReports}))
+	s.mux.HandleFunc("/api/reports/", s.locked(routes{http.MethodGet: s.handleApiReport}))
+	s.mux.HandleFunc("/api/attachments", s.locked(routes{http.MethodPost: s.authorized(s.handleUpload)}))
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// locked runs the handler for the request method while holding the store,
+// after picking up changes to the notes.
+func (s *Server) locked(methods routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		method := r.Method
+
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+
+		handler, ok := methods[method]
+
+		if !ok {
+			allow := make([]string, 0)
+			for m := range methods {
+				allow = append(allow, m)
+
+				if m == http.MethodGet {
+					allow = append(allow, http.MethodHead)
+				}
+			}
+			sort.Strings(allow)
+
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			s.fail(w, r, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if err := s.store.Refresh(); err != nil {
+			s.fail(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// fail writes an error as JSON for API requests and as text otherwise.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSON(w, status, errorResponse{Error: message})
+		return
+	}
+
+	http.Error(w, message, status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(value)
+}
+
+// render turns a note body into HTML with links pointing back into the server.
+// zk: links by title or alias are pointed at the note's id.
+func (s *Server) render(body string) template.HTML {
+	doc := markdown.Parse(body)
+	markdown.ResolveWikiLinks(doc, s.store.Resolve)
+
+	for _, l := range doc.Links() {
+		if l.Type == markdown.LNK_ZK && !l.Wiki {
+			if id, ok := s.store.Resolve(l.Target); ok {
+				l.Target = id
+			}
+		}
+	}
+
+	r := markdown.NewHtmlRenderer()
+	r.LinkURL = linkURL
+
+	return template.HTML(r.Render(doc))
+}
+
+// linkURL maps kn links to server paths. zk: links go to notes, rp: links to
+// reports and zka: links and images to attachments.
+func linkURL(l *markdown.Link) string {
+	switch l.Type {
+	case markdown.LNK_ZK:
+		return "/notes/" + url.PathEscape(l.Target)
+	case markdown.LNK_REPORT:
+		return "/reports/" + l.Target
+	case markdown.LNK_ZKA:
+		return "/attachments/" + url.PathEscape(filepath.Base(l.Target))
+	case markdown.LNK_IMAGE:
+		if strings.HasPrefix(l.Target, "zka:") {
+			return "/attachments/" + url.PathEscape(filepath.Base(l.Target[4:]))
+		}
+	}
+
+	return markdown.SafeURL(l)
+}
+
+// query filters notes by the q, mode, type and tag parameters. Without q every
+// note is listed by id, with it the notes come in search order.
+func (s *Server) query(params url.Values) ([]Note, error) {
+	var notes []Note
+
+	if q := params.Get("q"); q != "" {
+		mode := params.Get("mode")
+
+		if mode == "" {
+			mode = "fuzzy"
+		}
+
+		found, err := s.store.Search(q, mode, params["type"])
+
+		if err != nil {
+			return nil, err
+		}
+
+		notes = found
+	} else {
+		notes = s.store.Notes()
+		sort.Slice(notes, func(i, j int) bool { return notes[i].Id < notes[j].Id })
+
+		if types := params["type"]; len(types) > 0 {
+			notes = filterNotes(notes, func(n Note) bool { return contains(types, n.Type) })
+		}
+	}
+
+	for _, tag := range params["tag"] {
+		notes = filterNotes(notes, func(n Note) bool { return contains(n.Tags, tag) })
+	}
+
+	return notes, nil
+}
+
+func filterNotes(notes []Note, keep func(n Note) bool) []Note {
+	result := make([]Note, 0, len(notes))
+
+	for _, n := range notes {
+		if keep(n) {
+			result = append(result, n)
+		}
+	}
+
+	return result
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// detail reads a note with its rendered body and backlinks.
+func (s *Server) detail(note Note) noteDetail {
+	backlinks := s.store.Backlinks(note.Id)
+	sort.Slice(backlinks, func(i, j int) bool { return backlinks[i].Id < backlinks[j].Id })
+
+	return noteDetail{Note: note, Html: string(s.render(note.Body)), Backlinks: backlinks}
+}
+
+func (s *Server) handleApiNotes(w http.ResponseWriter, r *http.Request) {
+	notes, err := s.query(r.URL.Query())
+
+	if err != nil {
+		s.fail(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, notes)
+}
+
+func (s *Server) handleApiNote(w http.ResponseWriter, r *http.Request) {
+	note, ok := s.store.Note(strings.TrimPrefix(r.URL.Path, "/api/notes/"))
+
+	if !ok {
+		s.fail(w, r, http.StatusNotFound, "note not found")
+		return
+	}
+
+	setVersion(w, note.Version)
+	writeJSON(w, http.StatusOK, s.detail(note))
+}
+
+func (s *Server) handleApiReports(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.store.Reports())
+}
+
+func (s *Server) handleApiReport(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.store.Report(strings.TrimPrefix(r.URL.Path, "/api/reports/"))
+
+	if !ok {
+		s.fail(w, r, http.StatusNotFound, "report not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, noteDetail{Note: report, Html: string(s.render(report.Body)), Backlinks: []Note{}})
+}
+
+// handleAttachment serves a file from the attachment folder. Only plain file
+// names are accepted so requests can't leave the folder.
+func (s *Server) handleAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.fail(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/attachments/")
+
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, filepath.Join(s.store.AttachmentDir(), name))
+}
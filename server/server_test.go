@@ -0,0 +1,426 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/wiltaylor/kn/notefile"
+)
+
+// fakeStore is an in memory vault with its notes oldest first. Search matches
+// titles containing the query.
+type fakeStore struct {
+	notes       []Note
+	links       map[string][]string
+	attachments string
+	refreshes   int
+}
+
+func (f *fakeStore) Refresh() error {
+	f.refreshes++
+	return nil
+}
+
+func (f *fakeStore) Notes() []Note {
+	result := make([]Note, 0, len(f.notes))
+
+	for i := len(f.notes) - 1; i >= 0; i-- {
+		n := f.notes[i]
+		n.Body = ""
+		result = append(result, n)
+	}
+
+	return result
+}
+
+func (f *fakeStore) Note(target string) (Note, bool) {
+	for _, n := range f.notes {
+		if n.Id == target {
+			return n, true
+		}
+	}
+
+	return Note{}, false
+}
+
+func (f *fakeStore) Search(query string, mode string, types []string) ([]Note, error) {
+	if mode == "regex" {
+		return nil, errors.New("bad regex")
+	}
+
+	result := make([]Note, 0)
+
+	for _, n := range f.Notes() {
+		if strings.Contains(strings.ToLower(n.Title), strings.ToLower(query)) && (len(types) == 0 || contains(types, n.Type)) {
+			result = append(result, n)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *fakeStore) Backlinks(id string) []Note {
+	result := make([]Note, 0)
+
+	for _, n := range f.Notes() {
+		if contains(f.links[n.Id], id) {
+			result = append(result, n)
+		}
+	}
+
+	return result
+}
+
+func (f *fakeStore) Resolve(target string) (string, bool) {
+	for _, n := range f.notes {
+		if n.Id == target || n.Title == target {
+			return n.Id, true
+		}
+	}
+
+	return "", false
+}
+
+func (f *fakeStore) Reports() []string {
+	return []string{"dashboard"}
+}
+
+func (f *fakeStore) Report(name string) (Note, bool) {
+	if name != "dashboard" {
+		return Note{}, false
+	}
+
+	return Note{Title: "Dashboard", Body: "# Map\n - [First](zk:1)\n - [Tasks](rp:tasks)\n"}, true
+}
+
+func (f *fakeStore) AttachmentDir() string {
+	return f.attachments
+}
+
+func (f *fakeStore) Create(input NoteInput) (Note, error) {
+	if input.Type != nil && *input.Type == "bogus" {
+		return Note{}, fmt.Errorf("%w: unknown type bogus", ErrInvalid)
+	}
+
+	n := Note{Id: strconv.Itoa(len(f.notes) + 1), Title: "New Note", Type: "fleeting", Version: "v1"}
+	applyInput(&n, input)
+
+	n, err := reread(n)
+	if err != nil {
+		return Note{}, err
+	}
+
+	f.notes = append(f.notes, n)
+
+	return n, nil
+}
+
+func (f *fakeStore) Update(id string, version string, input NoteInput) (Note, error) {
+	for i, n := range f.notes {
+		if n.Id != id {
+			continue
+		}
+
+		if version != "" && version != n.Version {
+			return Note{}, ErrConflict
+		}
+
+		applyInput(&n, input)
+		n.Version += "+"
+
+		n, err := reread(n)
+		if err != nil {
+			return Note{}, err
+		}
+
+		f.notes[i] = n
+
+		return n, nil
+	}
+
+	return Note{}, ErrNotFound
+}
+
+func (f *fakeStore) Attach(name string, data io.Reader) (string, error) {
+	saved := "99" + filepath.Ext(name)
+	content, err := ioutil.ReadAll(data)
+
+	if err != nil {
+		return "", err
+	}
+
+	return saved, ioutil.WriteFile(filepath.Join(f.attachments, saved), content, 0660)
+}
+
+// reread writes a note the way the vault stores it and reads it back, so
+// fields that don't survive the front matter are lost like they would be.
+func reread(n Note) (Note, error) {
+	text, err := notefile.Format(notefile.Header{Title: n.Title, Date: n.Date, Type: n.Type, State: n.State, Tags: n.Tags, Aliases: n.Aliases}, n.Body)
+	if err != nil {
+		return Note{}, err
+	}
+
+	parts := strings.SplitN(string(text), "---\n", 3)
+	header, err := notefile.Parse(parts[1])
+	if err != nil {
+		return Note{}, err
+	}
+
+	n.Title, n.Date, n.Type, n.State = header.Title, header.Date, header.Type, header.State
+	n.Tags, n.Aliases, n.Body = header.Tags, header.Aliases, parts[2]
+
+	return n, nil
+}
+
+func applyInput(n *Note, input NoteInput) {
+	if input.Title != nil {
+		n.Title = *input.Title
+	}
+	if input.Type != nil {
+		n.Type = *input.Type
+	}
+	if input.Tags != nil {
+		n.Tags = *input.Tags
+	}
+	if input.Aliases != nil {
+		n.Aliases = *input.Aliases
+	}
+	if input.Body != nil {
+		n.Body = *input.Body
+	}
+}
+
+func newFakeStore(t *testing.T) *fakeStore {
+	dir := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "1234.txt"), []byte("attached"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	return &fakeStore{
+		notes: []Note{
+			{Id: "1", Title: "First", Date: "2021-01-01", Type: "zettle", Tags: []string{"go"}, Version: "v1", Body: "Links to [[Second]] and [file](zka:1234.txt) <script>\n"},
+			{Id: "2", Title: "Second", Date: "2021-01-02", Type: "map", Tags: []string{"go", "web"}, Version: "v1", Body: "Back to [one](zk:1) and [first](zk:First)\n"},
+			{Id: "3", Title: "Third", Date: "2021-01-03", Type: "zettle", Body: "[[Nowhere]]\n"},
+		},
+		links:       map[string][]string{"1": {"2"}, "2": {"1"}},
+		attachments: dir,
+	}
+}
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestApi(t *testing.T) {
+	store := newFakeStore(t)
+	s := New(store)
+
+	t.Run("Lists notes by id without bodies", func(t *testing.T) {
+		rec := get(t, s, "/api/notes")
+
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("Expected a JSON 200, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+		}
+
+		var notes []Note
+		if err := json.Unmarshal(rec.Body.Bytes(), &notes); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(notes) != 3 || notes[0].Id != "1" || notes[0].Body != "" {
+			t.Errorf("Unexpected notes %+v", notes)
+		}
+
+		if store.refreshes == 0 {
+			t.Errorf("Expected the store to be refreshed")
+		}
+	})
+
+	t.Run("Filters by query, type and tag", func(t *testing.T) {
+		cases := []struct {
+			path     string
+			expected []string
+		}{
+			{path: "/api/notes?q=ir", expected: []string{"3", "1"}},
+			{path: "/api/notes?q=ir&type=zettle&tag=go", expected: []string{"1"}},
+			{path: "/api/notes?type=map", expected: []string{"2"}},
+			{path: "/api/notes?tag=go&tag=web", expected: []string{"2"}},
+		}
+
+		for _, c := range cases {
+			var notes []Note
+			json.Unmarshal(get(t, s, c.path).Body.Bytes(), &notes)
+
+			ids := make([]string, 0)
+			for _, n := range notes {
+				ids = append(ids, n.Id)
+			}
+
+			if strings.Join(ids, ",") != strings.Join(c.expected, ",") {
+				t.Errorf("%s: expected %v, got %v", c.path, c.expected, ids)
+			}
+		}
+	})
+
+	t.Run("Reports bad queries", func(t *testing.T) {
+		rec := get(t, s, "/api/notes?q=(&mode=regex")
+
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"error": "bad regex"`) {
+			t.Errorf("Expected a JSON 400, got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Reads a note with its html and backlinks", func(t *testing.T) {
+		rec := get(t, s, "/api/notes/1")
+
+		var detail noteDetail
+		if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+			t.Fatal(err)
+		}
+
+		if detail.Title != "First" || !strings.HasPrefix(detail.Body, "Links to") {
+			t.Errorf("Unexpected note %+v", detail.Note)
+		}
+
+		if !strings.Contains(detail.Html, `<a href="/notes/2">Second</a>`) || !strings.Contains(detail.Html, `<a href="/attachments/1234.txt">file</a>`) {
+			t.Errorf("Links not rewritten in %s", detail.Html)
+		}
+
+		if strings.Contains(detail.Html, "<script>") {
+			t.Errorf("Expected html to be escaped, got %s", detail.Html)
+		}
+
+		if len(detail.Backlinks) != 1 || detail.Backlinks[0].Id != "2" {
+			t.Errorf("Unexpected backlinks %+v", detail.Backlinks)
+		}
+	})
+
+	t.Run("Missing notes are JSON 404s", func(t *testing.T) {
+		rec := get(t, s, "/api/notes/nope")
+
+		if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a JSON 404, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("Notes are read by id only", func(t *testing.T) {
+		if rec := get(t, s, "/api/notes/First"); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected a 404 for a title, got %d", rec.Code)
+		}
+
+		var detail noteDetail
+		json.Unmarshal(get(t, s, "/api/notes/2").Body.Bytes(), &detail)
+
+		if !strings.Contains(detail.Html, `<a href="/notes/1">first</a>`) {
+			t.Errorf("Expected links by title to point at the id, got %s", detail.Html)
+		}
+	})
+
+	t.Run("Lists and reads reports", func(t *testing.T) {
+		if body := get(t, s, "/api/reports").Body.String(); !strings.Contains(body, `"dashboard"`) {
+			t.Errorf("Unexpected reports %s", body)
+		}
+
+		if body := get(t, s, "/api/reports/dashboard").Body.String(); !strings.Contains(body, `href=\"/reports/tasks\"`) {
+			t.Errorf("Unexpected report %s", body)
+		}
+	})
+
+	t.Run("Refuses writes without a token", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/notes", strings.NewReader("{}")))
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", rec.Code)
+		}
+
+		rec = httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/notes/1", nil))
+
+		if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD, PUT" {
+			t.Errorf("Expected 405 allowing GET, HEAD, PUT, got %d %s", rec.Code, rec.Header().Get("Allow"))
+		}
+	})
+}
+
+func TestPages(t *testing.T) {
+	store := newFakeStore(t)
+	s := New(store)
+
+	t.Run("Index lists the newest notes first", func(t *testing.T) {
+		body := get(t, s, "/").Body.String()
+
+		if strings.Index(body, "Third") > strings.Index(body, "First") {
+			t.Errorf("Expected newest first in %s", body)
+		}
+
+		if !strings.Contains(body, `href="/reports/dashboard"`) {
+			t.Errorf("Expected reports in %s", body)
+		}
+	})
+
+	t.Run("Note pages show unresolved links and backlinks", func(t *testing.T) {
+		rec := get(t, s, "/notes/3")
+
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<span class="unresolved">Nowhere</span>`) {
+			t.Errorf("Unexpected page %d %s", rec.Code, rec.Body.String())
+		}
+
+		if body := get(t, s, "/notes/2").Body.String(); !strings.Contains(body, "Backlinks") || !strings.Contains(body, `<a href="/notes/1">First</a>`) {
+			t.Errorf("Expected backlinks in %s", body)
+		}
+
+		if rec := get(t, s, "/notes/nope"); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Search keeps the query", func(t *testing.T) {
+		body := get(t, s, "/search?q=sec").Body.String()
+
+		if !strings.Contains(body, `value="sec"`) || !strings.Contains(body, "Second") || strings.Contains(body, "Third") {
+			t.Errorf("Unexpected search page %s", body)
+		}
+	})
+
+	t.Run("Renders reports", func(t *testing.T) {
+		body := get(t, s, "/reports/dashboard").Body.String()
+
+		if !strings.Contains(body, `<a href="/notes/1">First</a>`) {
+			t.Errorf("Unexpected report page %s", body)
+		}
+
+		if rec := get(t, s, "/reports/nope"); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Serves attachments only from the attachment folder", func(t *testing.T) {
+		if body := get(t, s, "/attachments/1234.txt").Body.String(); body != "attached" {
+			t.Errorf("Expected the attachment, got %s", body)
+		}
+
+		secret := filepath.Join(filepath.Dir(store.attachments), "secret.txt")
+		ioutil.WriteFile(secret, []byte("secret"), 0660)
+		defer os.Remove(secret)
+
+		for _, path := range []string{"/attachments/..%2Fsecret.txt", "/attachments/", "/attachments/.hidden"} {
+			if rec := get(t, s, path); rec.Code == http.StatusOK {
+				t.Errorf("%s: expected an error, got %s", path, rec.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxNoteSize   = 10 << 20
+	maxUploadSize = 64 << 20
+)
+
+// attachmentResponse is the answer to an upload. Link is what a note uses to
+// link to the attachment.
+type attachmentResponse struct {
+	Name string `json:"name"`
+	Link string `json:"link"`
+	Url  string `json:"url"`
+}
+
+// authorized runs the handler only when the request carries the server token.
+func (s *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Token == "" {
+			s.fail(w, r, http.StatusForbidden, "writes are disabled, start the server with a token")
+			return
+		}
+
+		token := r.Header.Get("Authorization")
+
+		if !strings.HasPrefix(token, "Bearer ") || subtle.ConstantTimeCompare([]byte(token[len("Bearer "):]), []byte(s.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kn"`)
+			s.fail(w, r, http.StatusUnauthorized, "missing or wrong token")
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// failError answers with the status that matches a Store error.
+func (s *Server) failError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		s.fail(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrConflict):
+		s.fail(w, r, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, ErrInvalid):
+		s.fail(w, r, http.StatusBadRequest, err.Error())
+	default:
+		s.fail(w, r, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// setVersion sends the version of a note as its ETag.
+func setVersion(w http.ResponseWriter, version string) {
+	if version != "" {
+		w.Header().Set("ETag", `"`+version+`"`)
+	}
+}
+
+// readInput decodes the JSON body of a write. Unknown fields are refused so a
+// typo doesn't quietly drop a change.
+func readInput(w http.ResponseWriter, r *http.Request) (NoteInput, error) {
+	var input NoteInput
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxNoteSize))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&input); err != nil {
+		return input, err
+	}
+
+	return input, nil
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	input, err := readInput(w, r)
+
+	if err != nil {
+		s.fail(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	note, err := s.store.Create(input)
+
+	if err != nil {
+		s.failError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/notes/"+note.Id)
+	setVersion(w, note.Version)
+	writeJSON(w, http.StatusCreated, s.detail(note))
+}
+
+// handleUpdate changes a note. The request has to say which version of the note
+// it is based on, in If-Match or the version field, so edits made in between
+// are not overwritten. A version of * updates whatever is there.
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	input, err := readInput(w, r)
+
+	if err != nil {
+		s.fail(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	version := input.Version
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		version = strings.Trim(strings.TrimPrefix(strings.TrimSpace(match), "W/"), `"`)
+	}
+
+	if version == "" {
+		s.fail(w, r, http.StatusPreconditionRequired, "send the version of the note in If-Match or version")
+		return
+	}
+
+	if version == "*" {
+		version = ""
+	}
+
+	note, err := s.store.Update(strings.TrimPrefix(r.URL.Path, "/api/notes/"), version, input)
+
+	if err != nil {
+		s.failError(w, r, err)
+		return
+	}
+
+	setVersion(w, note.Version)
+	writeJSON(w, http.StatusOK, s.detail(note))
+}
+
+// handleUpload stores an attachment sent as the file field of a multipart form
+// or as the raw body with its file name in the name parameter.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	var data io.Reader
+	name := r.URL.Query().Get("name")
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+
+		if err != nil {
+			s.fail(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		defer file.Close()
+		data, name = file, header.Filename
+	} else {
+		data = r.Body
+	}
+
+	if name == "" {
+		s.fail(w, r, http.StatusBadRequest, "the attachment needs a file name")
+		return
+	}
+
+	saved, err := s.store.Attach(name, data)
+
+	if err != nil {
+		s.failError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, attachmentResponse{Name: saved, Link: "zka:" + saved, Url: "/attachments/" + saved})
+}
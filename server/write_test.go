@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func send(h http.Handler, method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWrites(t *testing.T) {
+	store := newFakeStore(t)
+	s := New(store)
+	s.Token = "secret"
+	auth := map[string]string{"Authorization": "Bearer secret"}
+
+	t.Run("Needs the token", func(t *testing.T) {
+		for _, headers := range []map[string]string{nil, {"Authorization": "Bearer wrong"}, {"Authorization": "secret"}} {
+			if rec := send(s, http.MethodPost, "/api/notes", `{"title": "x"}`, headers); rec.Code != http.StatusUnauthorized {
+				t.Errorf("%v: expected 401, got %d", headers, rec.Code)
+			}
+		}
+	})
+
+	t.Run("Creates notes", func(t *testing.T) {
+		rec := send(s, http.MethodPost, "/api/notes", `{"title": "Idea", "tags": ["inbox"], "body": "See [[First]]"}`, auth)
+
+		if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/api/notes/4" || rec.Header().Get("ETag") != `"v1"` {
+			t.Fatalf("Unexpected response %d %v %s", rec.Code, rec.Header(), rec.Body.String())
+		}
+
+		var detail noteDetail
+		json.Unmarshal(rec.Body.Bytes(), &detail)
+
+		if detail.Title != "Idea" || detail.Type != "fleeting" || detail.Tags[0] != "inbox" || !strings.Contains(detail.Html, `href="/notes/1"`) {
+			t.Errorf("Unexpected note %+v", detail)
+		}
+	})
+
+	t.Run("Keeps titles and tags yaml would misread", func(t *testing.T) {
+		rec := send(s, http.MethodPost, "/api/notes", `{"title": "Re: meeting #1", "tags": ["a, b", "[x]"], "aliases": ["- y"]}`, auth)
+
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Unexpected response %d %s", rec.Code, rec.Body.String())
+		}
+
+		var created noteDetail
+		json.Unmarshal(rec.Body.Bytes(), &created)
+
+		var notes []Note
+		json.Unmarshal(get(t, s, "/api/notes?q=meeting").Body.Bytes(), &notes)
+
+		if len(notes) != 1 || notes[0].Id != created.Id || notes[0].Title != "Re: meeting #1" || strings.Join(notes[0].Tags, "|") != "a, b|[x]" || notes[0].Aliases[0] != "- y" {
+			t.Errorf("Expected the note to read back, got %+v", notes)
+		}
+	})
+
+	t.Run("Rejects bad input", func(t *testing.T) {
+		cases := []string{`{"titel": "typo"}`, `not json`, `{"type": "bogus"}`}
+
+		for _, body := range cases {
+			rec := send(s, http.MethodPost, "/api/notes", body, auth)
+
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"error"`) {
+				t.Errorf("%s: expected a JSON 400, got %d %s", body, rec.Code, rec.Body.String())
+			}
+		}
+	})
+
+	t.Run("Updates need a version", func(t *testing.T) {
+		if rec := send(s, http.MethodPut, "/api/notes/1", `{"body": "x"}`, auth); rec.Code != http.StatusPreconditionRequired {
+			t.Errorf("Expected 428, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Updates the version they were based on", func(t *testing.T) {
+		etag := get(t, s, "/api/notes/1").Header().Get("ETag")
+
+		rec := send(s, http.MethodPut, "/api/notes/1", `{"body": "changed"}`, map[string]string{"Authorization": "Bearer secret", "If-Match": etag})
+
+		if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+			t.Fatalf("Unexpected response %d %v %s", rec.Code, rec.Header(), rec.Body.String())
+		}
+
+		if n, _ := store.Note("1"); n.Body != "changed" || n.Title != "First" {
+			t.Errorf("Expected only the body to change, got %+v", n)
+		}
+
+		// A second writer still holding the old version must not clobber it.
+		rec = send(s, http.MethodPut, "/api/notes/1", `{"body": "stale"}`, map[string]string{"Authorization": "Bearer secret", "If-Match": etag})
+
+		if rec.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expected 412, got %d", rec.Code)
+		}
+
+		if n, _ := store.Note("1"); n.Body != "changed" {
+			t.Errorf("Stale update was written: %+v", n)
+		}
+	})
+
+	t.Run("Takes the version from the body or *", func(t *testing.T) {
+		n, _ := store.Note("2")
+
+		if rec := send(s, http.MethodPut, "/api/notes/2", `{"title": "Renamed", "version": "`+n.Version+`"}`, auth); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d %s", rec.Code, rec.Body.String())
+		}
+
+		if rec := send(s, http.MethodPut, "/api/notes/2", `{"title": "Forced"}`, map[string]string{"Authorization": "Bearer secret", "If-Match": "*"}); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d %s", rec.Code, rec.Body.String())
+		}
+
+		if rec := send(s, http.MethodPut, "/api/notes/nope", `{"version": "v1"}`, auth); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Uploads attachments", func(t *testing.T) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", "photo.png")
+		part.Write([]byte("png data"))
+		form.Close()
+
+		rec := send(s, http.MethodPost, "/api/attachments", body.String(), map[string]string{"Authorization": "Bearer secret", "Content-Type": form.FormDataContentType()})
+
+		if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"link": "zka:99.png"`) {
+			t.Fatalf("Unexpected response %d %s", rec.Code, rec.Body.String())
+		}
+
+		if data, _ := ioutil.ReadFile(filepath.Join(store.attachments, "99.png")); string(data) != "png data" {
+			t.Errorf("Unexpected attachment %q", data)
+		}
+
+		if rec := send(s, http.MethodPost, "/api/attachments?name=clip.txt", "raw", auth); rec.Code != http.StatusCreated {
+			t.Errorf("Expected a raw upload to work, got %d %s", rec.Code, rec.Body.String())
+		}
+
+		if rec := send(s, http.MethodPost, "/api/attachments", "raw", auth); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 without a name, got %d", rec.Code)
+		}
+	})
+}
@@ -1,57 +1,97 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"fmt"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
-	"golang.design/x/clipboard"
 
   "github.com/wiltaylor/kn/markdown"
 )
 
-const (
-	LinkIconUrl        = ""
-	LinkIconEmpty      = ""
-	LinkIconNote       = ""
-	LinkIconAttachment = ""
-	LinkIconReport     = ""
-)
-
 type ViewMode int
+type SearchColumn int
 
 const (
 	ViewModeMain ViewMode = iota
 	ViewModeSearch
 	ViewModeSearchLink
+	ViewModePicker
+	ViewModeMenu
+	ViewModePrompt
+	ViewModePalette
+	ViewModeHelp
+)
+
+const (
+	ColumnRelevance SearchColumn = iota
+	ColumnTitle
+	ColumnType
+	ColumnState
+	ColumnDate
+	ColumnTags
+	ColumnLinks
+	ColumnBacklinks
 )
 
+// searchColumns are the headings of the search result table, in the same
+// order as the SearchColumn values after ColumnRelevance.
+var searchColumns = []string{"Title", "Type", "State", "Date", "Tags", "Links", "Backlinks"}
+
 // Main screen
 var app *tview.Application
 var toolbar *tview.TextView
 var textbox *tview.TextView
 var mainLayout *tview.Grid
+var renderer *markdown.TuiRenderer
+var renderedWidth int
+var outline *tview.List
+var outlineVisible bool
 
 // Search screen
 var searchLayout *tview.Grid
 var searchField *tview.InputField
 var searchResult *tview.Table
+var searchPreview *tview.TextView
 var typeForm *tview.Form
 var zettleCheck *tview.Checkbox
 
+// Picker screen for links that match more than one note
+var picker *tview.List
+var PickerResults []NoteHeader
+
+// Menu and prompt used by bulk actions, they return to the search screen
+var menu *tview.List
+var prompt *tview.InputField
+var menuReturn ViewMode
+
+// Help overlay listing the key bindings
+var help *tview.TextView
+var helpReturn ViewMode
+
 // Search Checkboxes
-var zettleChk, mapChk, litChk, fleetingChk bool
+var typeChecks map[NoteType]bool
 
 var CurrentViewMode ViewMode
 var CurrentSearchResults []NoteHeader
+var CurrentSearchMatches []SearchResult
+var MarkedNotes map[string]bool
+var CurrentSearchMode SearchMode
+var CurrentSearchSort SearchColumn
+var CurrentSearchDesc bool
 var CurrentNote NoteData
 var CurrentSearchSelection int
 var CurrentLinkIndex int
+var CurrentTaskIndex int
+var CurrentHeadingIndex int
+var CurrentHeadings []*markdown.Heading
 var NoteHistory []string
 
 func InitUI() {
@@ -61,7 +101,6 @@ func InitUI() {
 
 	// Main view controls
 	toolbar = tview.NewTextView()
-	toolbar.SetText("ESC-Quit|N-New|F-Find|E-Edit|A-AddLink|D-DeleteNote|C-CopyId|HJKL-Move|Enter-FollowLink|Backspace-Back|F1-Dashboard|F10-Sync")
 	toolbar.SetBackgroundColor(tcell.ColorWhite)
 	toolbar.SetTextColor(tcell.ColorBlack)
 
@@ -75,10 +114,20 @@ func InitUI() {
 	textbox.SetRegions(true)
 	CurrentLinkIndex = -1
 
+	renderer = markdown.NewTuiRenderer()
+	renderer.HeadingRegions = true
+	renderer.Folded = make(map[int]bool)
+	renderer.Embeds = LoadEmbed
+
+	outline = tview.NewList()
+	outline.ShowSecondaryText(false)
+	outline.SetHighlightFullLine(true)
+	outline.SetTitle("Outline")
+	outline.SetBorder(true)
+
 	mainLayout = tview.NewGrid()
 	mainLayout.SetRows(1, 5, 0)
-	mainLayout.AddItem(toolbar, 0, 0, 1, 1, 1, 1, false)
-	mainLayout.AddItem(textbox, 1, 0, 10, 1, 10, 40, true)
+	LayoutMain()
 
 	// Search Window controls
 	searchField = tview.NewInputField()
@@ -87,42 +136,66 @@ func InitUI() {
 	searchField.SetLabel("Note Title: ")
 
 	typeForm = tview.NewForm()
-	typeForm.AddCheckbox("Zettle", true, func(checked bool) {
-		zettleChk = checked
-	})
-	zettleChk = true
-
-	typeForm.AddCheckbox("Map", true, func(checked bool) {
-		mapChk = checked
-	})
-	mapChk = true
-
-	typeForm.AddCheckbox("Literature", false, func(checked bool) {
-		litChk = checked
-	})
-	litChk = false
-	typeForm.AddCheckbox("Fleeting", false, func(checked bool) {
-		fleetingChk = checked
-	})
-	fleetingChk = false
-
 	typeForm.SetHorizontal(true)
 	typeForm.SetBorder(true)
 
 	searchResult = tview.NewTable()
 	searchResult.SetSelectable(true, false)
+	searchResult.SetFixed(1, 0)
 	searchResult.SetSelectionChangedFunc(func(row int, _ int) {
-		CurrentSearchSelection = row
+		CurrentSearchSelection = row - 1
+		UpdatePreview()
 	})
 
+	searchPreview = tview.NewTextView()
+	searchPreview.SetDynamicColors(true)
+	searchPreview.SetRegions(true)
+	searchPreview.SetBorder(true)
+	searchPreview.SetTitle("Preview")
+
 	searchLayout = tview.NewGrid()
 	searchLayout.SetRows(1, 1, 0)
+	searchLayout.SetColumns(-3, -2)
 	searchLayout.SetMinSize(0, 0)
-	searchLayout.AddItem(searchField, 0, 0, 1, 1, 1, 1, true)
-	searchLayout.AddItem(typeForm, 2, 0, 1, 1, 1, 40, false)
+	searchLayout.AddItem(searchField, 0, 0, 1, 2, 1, 1, true)
+	searchLayout.AddItem(typeForm, 2, 0, 1, 2, 1, 40, false)
 	searchLayout.AddItem(searchResult, 3, 0, 10, 1, 10, 40, false)
+	searchLayout.AddItem(searchPreview, 3, 1, 10, 1, 10, 20, false)
+
+	picker = tview.NewList()
+	picker.SetTitle("Pick a note")
+	picker.SetBorder(true)
+	picker.SetHighlightFullLine(true)
+
+	menu = tview.NewList()
+	menu.ShowSecondaryText(false)
+	menu.SetBorder(true)
+	menu.SetHighlightFullLine(true)
+
+	prompt = tview.NewInputField()
+	prompt.SetBorder(true)
+
+	InitPalette()
+
+	help = tview.NewTextView()
+	help.SetDynamicColors(true)
+	help.SetScrollable(true)
+	help.SetBorder(true)
+	help.SetTitle("Keys")
+
+	MarkedNotes = make(map[string]bool)
+
+	ApplySettings()
 
 	app.SetInputCapture(handleInput)
+	app.SetAfterDrawFunc(func(screen tcell.Screen) {
+		_, _, width, _ := textbox.GetInnerRect()
+
+		// Tables are laid out for the textbox width so render again once it is known or changes.
+		if width != renderedWidth {
+			go app.QueueUpdateDraw(RenderCurrentNote)
+		}
+	})
 	app.SetRoot(mainLayout, true)
 	app.SetFocus(textbox)
 
@@ -132,6 +205,215 @@ func InitUI() {
 	RefreshFileView()
 }
 
+// ApplySettings sets up the parts of the UI that come from the config: the
+// renderer, the toolbar and the note type filters.
+func ApplySettings() {
+	renderer.Theme = markdown.Themes[Settings.Theme.Code]
+	renderer.LineNumbers = Settings.Theme.LineNumbers
+	renderer.Colors = Settings.Theme.Colors
+	renderer.LinkIcons = LinkIcons()
+
+	toolbar.SetText(ToolbarText("main"))
+
+	typeForm.Clear(true)
+	typeChecks = make(map[NoteType]bool)
+
+	for _, cfg := range NoteTypes() {
+		t := NoteType(cfg.Name)
+		typeChecks[t] = cfg.Search
+
+		typeForm.AddCheckbox(strings.Title(cfg.Name), cfg.Search, func(checked bool) {
+			typeChecks[t] = checked
+		})
+	}
+}
+
+// SwitchVault opens another vault from the config. Its notes replace the
+// loaded ones and the history starts again from the dashboard. The settings
+// are kept when the vault can't be loaded.
+func SwitchVault(name string) error {
+	settings, files := Settings, ConfigFiles
+
+	if err := LoadConfig(name); err != nil {
+		Settings, ConfigFiles = settings, files
+		return err
+	}
+
+	os.MkdirAll(filepath.Join(NoteDirectory, ".attachments"), 0760)
+	RefreshNotes()
+	ApplySettings()
+
+	NoteHistory = make([]string, 0)
+	MarkedNotes = make(map[string]bool)
+	CurrentNote = DashboardReport()
+	RefreshFileView()
+	return nil
+}
+
+// LayoutMain places the main screen controls, with the outline pane on the
+// left when it is visible.
+func LayoutMain() {
+	mainLayout.Clear()
+
+	if outlineVisible {
+		mainLayout.SetColumns(30, 0)
+		mainLayout.AddItem(toolbar, 0, 0, 1, 2, 1, 1, false)
+		mainLayout.AddItem(outline, 1, 0, 10, 1, 10, 20, false)
+		mainLayout.AddItem(textbox, 1, 1, 10, 1, 10, 40, true)
+		return
+	}
+
+	mainLayout.SetColumns(0)
+	mainLayout.AddItem(toolbar, 0, 0, 1, 1, 1, 1, false)
+	mainLayout.AddItem(textbox, 1, 0, 10, 1, 10, 40, true)
+}
+
+// ToggleOutline shows or hides the outline pane. The pane gets focus when it
+// is shown.
+func ToggleOutline() {
+	outlineVisible = !outlineVisible
+	LayoutMain()
+
+	if outlineVisible {
+		app.SetFocus(outline)
+	} else {
+		app.SetFocus(textbox)
+	}
+}
+
+// RefreshOutline lists the headings of the current note in the outline pane.
+func RefreshOutline() {
+	current := outline.GetCurrentItem()
+	outline.Clear()
+
+	for i, h := range CurrentHeadings {
+		title := strings.Repeat("  ", h.Level-1) + markdown.PlainText(h)
+
+		if renderer.Folded[i] {
+			title += " …"
+		}
+
+		outline.AddItem(tview.Escape(title), "", 0, nil)
+	}
+
+	if current < outline.GetItemCount() {
+		outline.SetCurrentItem(current)
+	}
+}
+
+// headingHidden reports if heading index sits inside a folded section.
+func headingHidden(index int) bool {
+	level := CurrentHeadings[index].Level
+
+	for i := index - 1; i >= 0; i-- {
+		if CurrentHeadings[i].Level >= level {
+			continue
+		}
+
+		if renderer.Folded[i] {
+			return true
+		}
+
+		level = CurrentHeadings[i].Level
+	}
+
+	return false
+}
+
+// linkHidden reports if a link sits inside a folded section, which runs from
+// the folded heading to the next heading of the same or higher level.
+func linkHidden(lnk *markdown.Link) bool {
+	offset := lnk.Pos().Offset
+
+	for i, h := range CurrentHeadings {
+		if !renderer.Folded[i] || offset < h.End().Offset {
+			continue
+		}
+
+		end := -1
+		for _, next := range CurrentHeadings[i+1:] {
+			if next.Level <= h.Level {
+				end = next.Pos().Offset
+				break
+			}
+		}
+
+		if end == -1 || offset < end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextLink highlights the next link that isn't hidden in a folded section.
+func NextLink() {
+	for range CurrentNote.Links {
+		CurrentLinkIndex++
+
+		if CurrentLinkIndex >= len(CurrentNote.Links) {
+			CurrentLinkIndex = 0
+		}
+
+		if !linkHidden(CurrentNote.Links[CurrentLinkIndex]) {
+			textbox.Highlight(fmt.Sprintf("%v", CurrentNote.Links[CurrentLinkIndex].Index))
+			textbox.ScrollToHighlight()
+			return
+		}
+	}
+}
+
+// JumpToHeading scrolls the viewer to a heading, unfolding any section that
+// hides it.
+func JumpToHeading(index int) {
+	if index < 0 || index >= len(CurrentHeadings) {
+		return
+	}
+
+	if headingHidden(index) {
+		level := CurrentHeadings[index].Level
+
+		for i := index - 1; i >= 0; i-- {
+			if CurrentHeadings[i].Level < level {
+				delete(renderer.Folded, i)
+				level = CurrentHeadings[i].Level
+			}
+		}
+
+		RenderCurrentNote()
+	}
+
+	CurrentHeadingIndex = index
+	textbox.Highlight(fmt.Sprintf("heading-%d", index))
+	textbox.ScrollToHighlight()
+}
+
+// ToggleFold folds or unfolds the section under a heading.
+func ToggleFold(index int) {
+	if index < 0 || index >= len(CurrentHeadings) {
+		return
+	}
+
+	if renderer.Folded[index] {
+		delete(renderer.Folded, index)
+	} else {
+		renderer.Folded[index] = true
+	}
+
+	RenderCurrentNote()
+	JumpToHeading(index)
+}
+
+// NextHeading jumps to the next visible heading in the given direction.
+func NextHeading(step int) {
+	for i := CurrentHeadingIndex + step; i >= 0 && i < len(CurrentHeadings); i += step {
+		if !headingHidden(i) {
+			JumpToHeading(i)
+			return
+		}
+	}
+}
+
 func SwitchView(mode ViewMode) {
 	switch mode {
 	case ViewModeMain:
@@ -147,334 +429,470 @@ func SwitchView(mode ViewMode) {
 		app.SetFocus(searchField)
 		SearchUpdate(searchField.GetText())
 		break
+	case ViewModePicker:
+		app.SetRoot(picker, true)
+		app.SetFocus(picker)
+		break
+	case ViewModeMenu:
+		app.SetRoot(menu, true)
+		app.SetFocus(menu)
+		break
+	case ViewModePrompt:
+		app.SetRoot(prompt, true)
+		app.SetFocus(prompt)
+		break
+	case ViewModePalette:
+		app.SetRoot(paletteLayout, true)
+		app.SetFocus(paletteField)
+		break
+	case ViewModeHelp:
+		app.SetRoot(help, true)
+		app.SetFocus(help)
+		break
 	}
 
 	CurrentViewMode = mode
 }
 
-func SearchUpdate(txt string) {
-	typs := make([]NoteType, 0)
-
-	if zettleChk {
-		typs = append(typs, ZettleNote)
+// ShowHelp shows the key bindings of every keymap over the current view.
+func ShowHelp() {
+	if CurrentViewMode != ViewModeHelp {
+		helpReturn = CurrentViewMode
 	}
 
-	if mapChk {
-		typs = append(typs, MapNote)
-	}
+	help.SetText(HelpText())
+	help.ScrollToBeginning()
+	SwitchView(ViewModeHelp)
+}
 
-	if litChk {
-		typs = append(typs, LiteratureNote)
-	}
+// ShowPicker lets the user choose between the notes a link matched.
+func ShowPicker(notes []NoteHeader) {
+	PickerResults = notes
+	picker.Clear()
 
-	if fleetingChk {
-		typs = append(typs, FleetingNote)
+	for _, note := range notes {
+		picker.AddItem(tview.Escape(note.Title), note.Id, 0, nil)
 	}
 
-	CurrentSearchResults = FindNotes(txt, typs)
+	SwitchView(ViewModePicker)
+}
 
-	searchResult.Clear()
+// OpenNote makes the note current and adds it to the history.
+func OpenNote(header NoteHeader) {
+	// Notes in other vaults open in their own vault.
+	if vault, id, ok := SplitVaultLink(header.Id); ok {
+		if err := SwitchVault(vault); err != nil {
+			return
+		}
 
-	for idx, item := range CurrentSearchResults {
-		searchResult.SetCellSimple(idx, 0, item.Title)
+		if header, ok = ResolveNote(id); !ok {
+			return
+		}
 	}
 
-	if len(CurrentSearchResults)-1 > CurrentSearchSelection {
-		CurrentSearchSelection = 0
+	n, err := GetNoteData(header)
+
+	if err != nil {
+		return
 	}
+
+	CurrentNote = n
+	NoteHistory = append(NoteHistory, CurrentNote.Header.Id)
+	RefreshFileView()
 }
 
-func handleInput(event *tcell.EventKey) *tcell.EventKey {
+func SearchUpdate(txt string) {
+	typs := make([]NoteType, 0)
 
-	if CurrentViewMode == ViewModeMain {
-		if event.Key() == tcell.KeyF10 {
+	for _, t := range KnownTypes() {
+		if typeChecks[t] {
+			typs = append(typs, t)
+		}
+	}
 
-			app.Suspend(func() {
-				if DoDataSync() == false {
-					fmt.Println("Press enter key to continue...")
-					bufio.NewReader(os.Stdin).ReadBytes('\n')
-				}
-			})
+	searchField.SetLabel(fmt.Sprintf("Note Title (%v): ", CurrentSearchMode))
+	searchResult.Clear()
+	CurrentSearchResults = make([]NoteHeader, 0)
+	CurrentSearchMatches = make([]SearchResult, 0)
 
-		}
+	results, err := SearchNotes(txt, CurrentSearchMode, typs)
 
-		if event.Key() == tcell.KeyF1 {
-			CurrentNote = OpenReport("rp:dashboard")
-			RefreshFileView()
-			return nil
-		}
+	if err != nil {
+		searchResult.SetCell(0, 0, tview.NewTableCell(tview.Escape(err.Error())).SetTextColor(tcell.ColorRed).SetSelectable(false))
+		UpdatePreview()
+		return
+	}
 
-		if event.Key() == tcell.KeyEsc {
-			ShutdownUI()
-			return nil
-		}
+	backlinks := BacklinkCounts()
+	sortSearchResults(results, backlinks)
 
-		if event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyBackspace2 {
-			if len(NoteHistory) <= 1 {
-				return nil
+	for col, name := range searchColumns {
+		if SearchColumn(col+1) == CurrentSearchSort {
+			if CurrentSearchDesc {
+				name += " ▼"
+			} else {
+				name += " ▲"
 			}
+		}
 
-			id := NoteHistory[len(NoteHistory)-2]
+		searchResult.SetCell(0, col, tview.NewTableCell(name).SetAttributes(tcell.AttrBold).SetSelectable(false))
+	}
 
-			NoteHistory = NoteHistory[0 : len(NoteHistory)-2]
+	for idx, item := range results {
+		note := item.Note
+		row := idx + 1
+
+		CurrentSearchResults = append(CurrentSearchResults, note)
+		CurrentSearchMatches = append(CurrentSearchMatches, item)
+		searchResult.SetCell(row, 0, tview.NewTableCell(searchTitle(item)).SetExpansion(1))
+		searchResult.SetCell(row, 1, tview.NewTableCell(TypeLabel(note.Type)))
+		searchResult.SetCell(row, 2, tview.NewTableCell(note.State.String()))
+		searchResult.SetCell(row, 3, tview.NewTableCell(formatNoteDate(note)))
+		searchResult.SetCell(row, 4, tview.NewTableCell(tview.Escape(strings.Join(note.Tags, ", "))))
+		searchResult.SetCell(row, 5, tview.NewTableCell(fmt.Sprintf("%d", len(LinkIndex()[note.Id]))).SetAlign(tview.AlignRight))
+		searchResult.SetCell(row, 6, tview.NewTableCell(fmt.Sprintf("%d", backlinks[note.Id])).SetAlign(tview.AlignRight))
+	}
 
-			head, err := GetHeaderFromFile(id)
+	CurrentSearchSelection = 0
+	searchResult.Select(1, 0)
+	UpdatePreview()
+}
 
-			if err != nil {
-				panic(err)
-			}
+// searchTitle renders the title cell of a search result with a mark for
+// selected notes.
+func searchTitle(item SearchResult) string {
+	if MarkedNotes[item.Note.Id] {
+		return "[green]●[-] " + highlightMatches(item.Note.Title, item.Positions)
+	}
 
-			d, err := GetNoteData(head)
+	return "  " + highlightMatches(item.Note.Title, item.Positions)
+}
 
-			if err != nil {
-				panic(err)
-			}
+// ToggleMark marks or unmarks the selected search result and moves down.
+func ToggleMark() {
+	if CurrentSearchSelection < 0 || CurrentSearchSelection >= len(CurrentSearchMatches) {
+		return
+	}
 
-			CurrentNote = d
-			RefreshFileView()
-			return nil
-		}
+	item := CurrentSearchMatches[CurrentSearchSelection]
 
-		if event.Key() == tcell.KeyEnter {
-			if CurrentLinkIndex == -1 {
-				return nil
-			}
+	if MarkedNotes[item.Note.Id] {
+		delete(MarkedNotes, item.Note.Id)
+	} else {
+		MarkedNotes[item.Note.Id] = true
+	}
 
-			if len(CurrentNote.Links) == 0 {
+	row := CurrentSearchSelection + 1
+	searchResult.GetCell(row, 0).SetText(searchTitle(item))
 
-				return nil
-			}
+	if row < len(CurrentSearchMatches) {
+		searchResult.Select(row+1, 0)
+	}
+}
 
-			lnk := CurrentNote.Links[CurrentLinkIndex]
+// ShowMenu lists items and calls selected with the index of the chosen one.
+func ShowMenu(title string, items []string, selected func(idx int)) {
+	if CurrentViewMode != ViewModeMenu && CurrentViewMode != ViewModePrompt {
+		menuReturn = CurrentViewMode
+	}
 
-			if lnk.Type == LinkUrl {
-				cmd := exec.Command("xdg-open", lnk.Path)
-				cmd.Start()
+	menu.Clear()
+	menu.SetTitle(title)
 
-				return nil
-			}
+	for i, item := range items {
+		idx := i
+		menu.AddItem(tview.Escape(item), "", 0, func() {
+			selected(idx)
+		})
+	}
 
-			if lnk.Type == LinkAttachment {
-				if len(lnk.Path) <= 4 {
-					return nil
-				}
+	SwitchView(ViewModeMenu)
+}
 
-				path := filepath.Join(NoteDirectory, ".attachments", lnk.Path[4:])
+// ShowPrompt asks for a line of text and calls done with it.
+func ShowPrompt(label string, done func(text string)) {
+	if CurrentViewMode != ViewModeMenu && CurrentViewMode != ViewModePrompt {
+		menuReturn = CurrentViewMode
+	}
 
-				cmd := exec.Command("xdg-open", path)
-				cmd.Start()
-				return nil
-			}
+	prompt.SetLabel(label)
+	prompt.SetText("")
+	prompt.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter && prompt.GetText() != "" {
+			done(prompt.GetText())
+			return
+		}
 
-			if lnk.Type == LinkReport {
-				CurrentNote = OpenReport(lnk.Path)
-				RefreshFileView()
-				return nil
-			}
+		SwitchView(menuReturn)
+	})
 
-			if lnk.Type == LinkNote {
-				id := lnk.Path[3:]
-				h, err := GetHeaderFromFile(id)
+	SwitchView(ViewModePrompt)
+}
 
-				if err != nil {
+// selectedNotes returns the marked notes, or the highlighted one when nothing
+// is marked.
+func selectedNotes() []NoteHeader {
+	result := make([]NoteHeader, 0)
 
-					return nil
-				}
+	for _, note := range AllNotes {
+		if MarkedNotes[note.Id] {
+			result = append(result, note)
+		}
+	}
 
-				n, err := GetNoteData(h)
+	if len(result) == 0 && CurrentSearchSelection >= 0 && CurrentSearchSelection < len(CurrentSearchResults) {
+		result = append(result, CurrentSearchResults[CurrentSearchSelection])
+	}
 
-				if err != nil {
+	return result
+}
 
-					return nil
-				}
+// ShowBulkMenu offers the actions that work on all marked notes.
+func ShowBulkMenu() {
+	notes := selectedNotes()
 
-				CurrentNote = n
-				NoteHistory = append(NoteHistory, CurrentNote.Header.Id)
-				RefreshFileView()
+	if len(notes) == 0 {
+		return
+	}
 
-			}
+	done := func() {
+		MarkedNotes = make(map[string]bool)
+		SwitchView(ViewModeSearch)
+	}
 
-			return nil
+	update := func(fn func(header *NoteHeader)) {
+		for _, note := range notes {
+			UpdateNoteHeader(note.Id, fn)
 		}
+		done()
+	}
 
-		if event.Key() == tcell.KeyTab {
-			if len(CurrentNote.Links) == 0 {
-				return nil
-			}
-
-			CurrentLinkIndex++
+	types := KnownTypes()
+	states := make([]NoteState, 0)
 
-			if CurrentLinkIndex >= len(CurrentNote.Links) {
-				CurrentLinkIndex = 0
+	// Offer every state from the workflows of the selected notes.
+	for _, note := range notes {
+		for _, state := range Workflow(note.Type) {
+			found := false
+			for _, s := range states {
+				found = found || s == state
 			}
 
-			textbox.Highlight(fmt.Sprintf("%v", CurrentNote.Links[CurrentLinkIndex].Id))
-			textbox.ScrollToHighlight()
-
-			return nil
+			if !found {
+				states = append(states, state)
+			}
 		}
+	}
 
-    if event.Rune() == 'c' {
-      clipboard.Write(clipboard.FmtText, []byte(CurrentNote.Header.Id))
-      return nil
-    }
-
-		if event.Rune() == 'f' {
-			SwitchView(ViewModeSearch)
-			return nil
-		}
+	actions := []string{"Change type", "Change state", "Add tag", "Remove tag", "Move to trash", "Link from current note", "New map note linking them"}
 
-		if event.Rune() == 'e' {
-			if CurrentNote.Header.Filename != "" {
-				EditFile(CurrentNote.Header.Filename)
-				RefreshNote(CurrentNote.Header.Id)
-				RefreshFileView()
+	ShowMenu(fmt.Sprintf("%d notes", len(notes)), actions, func(idx int) {
+		switch idx {
+		case 0:
+			names := make([]string, 0, len(types))
+			for _, t := range types {
+				names = append(names, t.String())
 			}
-			return nil
-		}
 
-		if event.Rune() == 'a' {
-			SwitchView(ViewModeSearchLink)
-			return nil
-		}
-
-		if event.Rune() == 'd' {
-			RemoveNote(CurrentNote.Header.Id)
-			CurrentNote.Header.Id = ""
-			CurrentNote.Header.Filename = ""
-			CurrentNote.Header.Title = "Empty"
-			CurrentNote.RawText = ""
-			CurrentNote.Links = make([]NoteLink, 0)
+			ShowMenu("Type", names, func(idx int) {
+				update(func(header *NoteHeader) { header.Type = types[idx] })
+			})
+		case 1:
+			names := make([]string, 0, len(states))
+			for _, s := range states {
+				names = append(names, s.String())
+			}
 
-			textbox.SetText("")
-			textbox.ScrollToBeginning()
-			textbox.SetTitle("Empty")
+			ShowMenu("State", names, func(idx int) {
+				update(func(header *NoteHeader) { header.State = states[idx] })
+			})
+		case 2:
+			ShowPrompt("Add tag: ", func(tag string) {
+				update(func(header *NoteHeader) { AddTag(header, tag) })
+			})
+		case 3:
+			ShowPrompt("Remove tag: ", func(tag string) {
+				update(func(header *NoteHeader) { RemoveTag(header, tag) })
+			})
+		case 4:
+			trashed := make(map[string]bool)
+			for _, note := range notes {
+				if TrashNote(note.Id) == nil {
+					trashed[note.Id] = true
+				}
+			}
 
-			return nil
-		}
+			// Trashed notes can't be shown or gone back to.
+			history := make([]string, 0, len(NoteHistory))
+			for _, id := range NoteHistory {
+				if !trashed[id] {
+					history = append(history, id)
+				}
+			}
+			NoteHistory = history
 
-		if event.Rune() == 'n' {
-			note, err := NewNote("New Note", ZettleNote)
+			if trashed[CurrentNote.Header.Id] {
+				CurrentNote = DashboardReport()
+				RefreshFileView()
+			}
+			done()
+		case 5:
+			if CurrentNote.Header.Type == ReportNote || CurrentNote.Header.Filename == "" {
+				done()
+				return
+			}
 
-			NoteHistory = append(NoteHistory, note.Header.Id)
+			// Read the note again so changes made since it was opened are kept.
+			header, err := GetHeaderFromFile(CurrentNote.Header.Id)
+			if err != nil {
+				done()
+				return
+			}
 
+			current, err := GetNoteData(header)
 			if err != nil {
-				panic(err)
+				done()
+				return
 			}
 
-			CurrentNote = note
-			EditFile(CurrentNote.Header.Filename)
-			RefreshNote(CurrentNote.Header.Id)
-			RefreshFileView()
-		}
-	}
+			for _, note := range notes {
+				current.RawText += fmt.Sprintf("\n[%s](zk:%s)\n", note.Title, note.Id)
+			}
 
-	if CurrentViewMode == ViewModeSearch || CurrentViewMode == ViewModeSearchLink {
-		if event.Key() == tcell.KeyEsc {
-			SwitchView(ViewModeMain)
-			return nil
-		}
+			if SaveNoteData(current) == nil {
+				RefreshNote(current.Header.Id)
+				CurrentNote = current
+				RefreshFileView()
+			}
+			done()
+		case 6:
+			ShowPrompt("Map title: ", func(title string) {
+				note, err := NewNote(title, MapNote)
 
-		if searchResult.HasFocus() && event.Rune() == 'c' {
-			note := CurrentSearchResults[CurrentSearchSelection]
-			clipboard.Write(clipboard.FmtText, []byte(note.Id))
+				if err != nil {
+					done()
+					return
+				}
+
+				for _, n := range notes {
+					note.RawText += fmt.Sprintf(" - [%s](zk:%s)\n", n.Title, n.Id)
+				}
+				SaveNoteData(note)
 
-			return nil
+				MarkedNotes = make(map[string]bool)
+				SwitchView(ViewModeMain)
+				OpenNote(note.Header)
+			})
 		}
+	})
+}
 
-		if event.Key() == tcell.KeyLeft {
-			idx := 0
+func formatNoteDate(note NoteHeader) string {
+	t := NoteTime(note)
 
-			for {
-				if idx >= typeForm.GetFormItemCount() {
-					return nil
-				}
+	if t.IsZero() {
+		return ""
+	}
 
-				ctrl := typeForm.GetFormItem(idx)
-				if ctrl.HasFocus() {
+	return t.Format("2006-01-02")
+}
 
-					if idx == 0 {
-						app.SetFocus(typeForm.GetFormItem(typeForm.GetFormItemCount() - 1))
-						return nil
-					}
+// sortSearchResults orders results by the current sort column. Relevance
+// keeps the order from SearchNotes.
+func sortSearchResults(results []SearchResult, backlinks map[string]int) {
+	if CurrentSearchSort == ColumnRelevance {
+		return
+	}
 
-					app.SetFocus(typeForm.GetFormItem(idx - 1))
-					return nil
-				}
-				idx++
+	less := func(a NoteHeader, b NoteHeader) bool {
+		switch CurrentSearchSort {
+		case ColumnTitle:
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		case ColumnType:
+			return a.Type.String() < b.Type.String()
+		case ColumnState:
+			if a.Type == b.Type {
+				return StateIndex(a.Type, a.State) < StateIndex(b.Type, b.State)
 			}
+			return a.State < b.State
+		case ColumnDate:
+			return NoteTime(a).Before(NoteTime(b))
+		case ColumnTags:
+			return strings.Join(a.Tags, ",") < strings.Join(b.Tags, ",")
+		case ColumnLinks:
+			return len(LinkIndex()[a.Id]) < len(LinkIndex()[b.Id])
+		case ColumnBacklinks:
+			return backlinks[a.Id] < backlinks[b.Id]
 		}
 
-		if event.Key() == tcell.KeyRight {
-			idx := 0
-
-			for {
-				if idx >= typeForm.GetFormItemCount() {
-					return event
-				}
+		return false
+	}
 
-				ctrl := typeForm.GetFormItem(idx)
-				if ctrl.HasFocus() {
+	sort.SliceStable(results, func(i, j int) bool {
+		if CurrentSearchDesc {
+			return less(results[j].Note, results[i].Note)
+		}
 
-					if idx == typeForm.GetFormItemCount()-1 {
-						app.SetFocus(typeForm.GetFormItem(0))
-						return nil
-					}
+		return less(results[i].Note, results[j].Note)
+	})
+}
 
-					app.SetFocus(typeForm.GetFormItem(idx + 1))
-					return nil
-				}
+// UpdatePreview renders the selected search result in the preview pane.
+func UpdatePreview() {
+	searchPreview.Clear()
 
-				idx++
-			}
-		}
+	if CurrentSearchSelection < 0 || CurrentSearchSelection >= len(CurrentSearchResults) {
+		searchPreview.SetTitle("Preview")
+		return
+	}
 
-		if event.Key() == tcell.KeyEnter {
-			if len(CurrentSearchResults) == 0 {
-				return nil
-			}
+	header := CurrentSearchResults[CurrentSearchSelection]
+	note, err := GetNoteData(header)
 
-			if CurrentViewMode == ViewModeSearchLink {
-				note := CurrentSearchResults[CurrentSearchSelection]
-				CurrentNote.RawText += fmt.Sprintf("\n[%s](zk:%s)\n", note.Title, note.Id)
-				SaveNoteData(CurrentNote)
-			} else {
+	if err != nil {
+		return
+	}
 
-				n, err := GetNoteData(CurrentSearchResults[CurrentSearchSelection])
+	// Render like the viewer, without its folds and heading regions.
+	preview := *renderer
+	preview.HeadingRegions = false
+	preview.Folded = make(map[int]bool)
+	preview.Width = 0
+	preview.NoteId = header.Id
 
-				if err != nil {
-					return nil
-				}
+	doc := markdown.Parse(note.RawText)
+	markdown.ResolveWikiLinks(doc, ResolveNoteId)
 
-				CurrentNote = n
-				NoteHistory = append(NoteHistory, CurrentNote.Header.Id)
-			}
-			RefreshFileView()
-			SwitchView(ViewModeMain)
-		}
+	searchPreview.SetTitle(header.Title)
+	searchPreview.SetText(preview.Render(doc))
+	searchPreview.ScrollToBeginning()
+}
 
-		if event.Key() == tcell.KeyTab {
-			if searchField.HasFocus() {
-				app.SetFocus(searchResult)
-				return nil
-			}
+// highlightMatches marks the runes of text at positions for a table cell.
+func highlightMatches(text string, positions []int) string {
+	result := ""
+	matched := make(map[int]bool)
 
-			if searchResult.HasFocus() {
-				app.SetFocus(typeForm)
-				return nil
-			}
+	for _, p := range positions {
+		matched[p] = true
+	}
 
-			if typeForm.HasFocus() {
-				app.SetFocus(searchField)
-				return nil
-			}
-			return nil
+	idx := 0
+	for _, c := range text {
+		if matched[idx] {
+			result += "[yellow::b]" + tview.Escape(string(c)) + "[-::-]"
+		} else {
+			result += tview.Escape(string(c))
 		}
+		idx++
+	}
 
-		if event.Key() == tcell.KeyF5 {
-			RefreshNotes()
-			SearchUpdate(searchField.GetText())
-		}
+	return result
+}
+
+func handleInput(event *tcell.EventKey) *tcell.EventKey {
+	if DispatchKey(ActiveKeymaps(), event) {
+		return nil
 	}
 
 	return event
@@ -491,6 +909,66 @@ func RunUI() {
 	}
 }
 
+// CreateNote makes a new note of the given type and opens it in the editor.
+func CreateNote(noteType NoteType, title string) {
+	if title == "" {
+		title = "New Note"
+	}
+
+	note, err := NewNote(title, noteType)
+
+	NoteHistory = append(NoteHistory, note.Header.Id)
+
+	if err != nil {
+		panic(err)
+	}
+
+	CurrentNote = note
+	EditFile(CurrentNote.Header.Filename)
+	RefreshNote(CurrentNote.Header.Id)
+	RefreshFileView()
+}
+
+// SetCurrentState writes a new state to the current note.
+func SetCurrentState(state NoteState) {
+	UpdateCurrentHeader(func(header *NoteHeader) {
+		header.State = state
+	})
+}
+
+// UpdateCurrentHeader changes the header of the current note. The note is read
+// again first so edits made outside kn are kept.
+func UpdateCurrentHeader(update func(header *NoteHeader)) {
+	if CurrentNote.Header.Type == ReportNote || CurrentNote.Header.Filename == "" {
+		return
+	}
+
+	if err := UpdateNoteHeader(CurrentNote.Header.Id, update); err != nil {
+		return
+	}
+
+	if header, err := GetHeaderFromFile(CurrentNote.Header.Id); err == nil {
+		CurrentNote.Header = header
+	}
+
+	textbox.SetTitle(noteTitle(CurrentNote.Header))
+}
+
+// noteTitle is the title of the note viewer, notes show their state.
+func noteTitle(header NoteHeader) string {
+	title := header.Title
+
+	if header.Type != ReportNote && header.Filename != "" {
+		title = fmt.Sprintf("%s (%s)", header.Title, header.State)
+	}
+
+	if Settings.Vault != "" {
+		title = Settings.Vault + ": " + title
+	}
+
+	return title
+}
+
 func RefreshFileView() {
 
 	if CurrentNote.Header.Type != ReportNote {
@@ -502,25 +980,74 @@ func RefreshFileView() {
 		CurrentNote = note
 	}
 
-  txt, _ := markdown.MarkdownToTui(CurrentNote.RawText)
-  CurrentNote.FormatedText = txt
+	renderer.Folded = make(map[int]bool)
+	RenderCurrentNote()
 
-	textbox.SetText(CurrentNote.FormatedText)
-	textbox.SetTitle(CurrentNote.Header.Title)
+	textbox.SetTitle(noteTitle(CurrentNote.Header))
 	textbox.Highlight()
 	textbox.ScrollToBeginning()
 	CurrentLinkIndex = -1
+	CurrentTaskIndex = -1
+	CurrentHeadingIndex = -1
+	outline.SetCurrentItem(0)
 }
 
-func EditFile(filename string) {
-	editor := os.Getenv("EDITOR")
+// RenderCurrentNote renders the current note to fit the width of the textbox.
+func RenderCurrentNote() {
+	_, _, width, _ := textbox.GetInnerRect()
+	renderer.Width = width
+	renderer.NoteId = CurrentNote.Header.Id
+	renderedWidth = width
+
+	doc := markdown.Parse(CurrentNote.RawText)
+	markdown.ResolveWikiLinks(doc, ResolveNoteId)
+	CurrentHeadings = doc.Headings()
+
+	CurrentNote.FormatedText = renderer.Render(doc)
+	textbox.SetText(CurrentNote.FormatedText)
+	RefreshOutline()
+}
+
+// OpenExternal opens a url or file with the configured opener.
+func OpenExternal(target string) {
+	args := append(strings.Fields(Settings.Opener), target)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Start()
+}
+
+// clipboardCommands are tried in order when no clipboard command is set.
+var clipboardCommands = []string{"wl-copy", "xclip -selection clipboard", "xsel --clipboard --input", "pbcopy", "clip"}
+
+// CopyText puts text on the clipboard by piping it into the clipboard
+// command, so only the TUI needs a display.
+func CopyText(text string) error {
+	command := Settings.Clipboard
 
-	if editor == "" {
-		editor = "vim"
+	if command == "" {
+		for _, c := range clipboardCommands {
+			if _, err := exec.LookPath(strings.Fields(c)[0]); err == nil {
+				command = c
+				break
+			}
+		}
 	}
 
+	if command == "" {
+		return errors.New("no clipboard command found")
+	}
+
+	args := strings.Fields(command)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+
+	return cmd.Run()
+}
+
+func EditFile(filename string) {
+	args := append(strings.Fields(Settings.Editor), filename)
+
 	app.Suspend(func() {
-		cmd := exec.Command(editor, filename)
+		cmd := exec.Command(args[0], args[1:]...)
 		cmd.Stderr = os.Stderr
 		cmd.Stdout = os.Stdout
 		cmd.Stdin = os.Stdin